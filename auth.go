@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/wkumari/sync_telechat/telechat"
+)
+
+// A user and password for basic auth.
+type credential struct {
+	user     string
+	password string
+}
+
+// Adds basic auth to requests for the hosts we have credentials for. As
+// this is done for each request on its way out (redirects included), a
+// redirect to another host never gets the credentials for the first one.
+type authTransport struct {
+	// By lower case host, with the port if it's only for that port.
+	credentials map[string]credential
+	next        http.RoundTripper
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	cred, ok := t.credentials[strings.ToLower(req.URL.Host)]
+	if !ok {
+		cred, ok = t.credentials[strings.ToLower(req.URL.Hostname())]
+	}
+	if !ok {
+		return t.next.RoundTrip(req)
+	}
+	// RoundTrippers must not modify the caller's request.
+	req = req.Clone(req.Context())
+	req.SetBasicAuth(cred.user, cred.password)
+	return t.next.RoundTrip(req)
+}
+
+// Returns the host --auth-user is for: --auth-host, or else the one in
+// --doc-base-url.
+func authHost(opts options) (string, error) {
+	host := opts.authHost
+	if host == "" && opts.docBaseURL != "" {
+		base, err := url.Parse(opts.docBaseURL)
+		if err != nil {
+			return "", fmt.Errorf("bad --doc-base-url %q: %v", opts.docBaseURL, err)
+		}
+		host = base.Host
+	}
+	if host == "" {
+		return "", fmt.Errorf("--auth-user needs --auth-host (or --doc-base-url)")
+	}
+	return strings.ToLower(host), nil
+}
+
+// Returns the (lower case) hosts we might make requests to, given opts.
+func configuredHosts(opts options) []string {
+	urls := []string{opts.baseurl}
+	if opts.from != "" {
+		urls = append(urls, telechat.TelechatDatesURL)
+	}
+	if opts.docBaseURL != "" {
+		urls = append(urls, opts.docBaseURL)
+	} else {
+		urls = append(urls, telechat.DocURL, telechat.ArchiveURL, telechat.CharterURL, telechat.RFCURL)
+	}
+	for _, template := range opts.urlTemplates {
+		urls = append(urls, template)
+	}
+	urls = append(urls, opts.mirrors...)
+
+	var hosts []string
+	seen := make(map[string]bool)
+	for _, u := range urls {
+		parsed, err := url.Parse(u)
+		if err != nil || parsed.Host == "" {
+			continue // Not a URL, e.g. a local --agenda.
+		}
+		host := strings.ToLower(parsed.Host)
+		if !seen[host] {
+			seen[host] = true
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// Reads the credentials for hosts from the netrc file at path (in the usual
+// format, as used by curl and ftp), by host name (netrc has no ports).
+// Entries for other hosts, "default" and macros are ignored.
+func readNetrc(path string, hosts []string) (map[string]credential, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	wanted := make(map[string]bool)
+	for _, host := range hosts {
+		wanted[strings.ToLower((&url.URL{Host: host}).Hostname())] = true
+	}
+	credentials := make(map[string]credential)
+	var machine string // The entry we're in, if we want it.
+	var cred credential
+	done := func() {
+		if machine != "" {
+			credentials[machine] = cred
+		}
+		machine, cred = "", credential{}
+	}
+
+	scanner := bufio.NewScanner(file)
+	inMacro := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		// Macros run to the next blank line.
+		if inMacro {
+			inMacro = strings.TrimSpace(line) != ""
+			continue
+		}
+		fields := strings.Fields(line)
+		for i := 0; i < len(fields); i++ {
+			if strings.HasPrefix(fields[i], "#") {
+				break
+			}
+			value := ""
+			if i+1 < len(fields) {
+				value = fields[i+1]
+			}
+			switch fields[i] {
+			case "machine":
+				done()
+				if wanted[strings.ToLower(value)] {
+					machine = strings.ToLower(value)
+				}
+				i++
+			case "default":
+				done()
+			case "login":
+				cred.user = value
+				i++
+			case "password":
+				cred.password = value
+				i++
+			case "account":
+				i++
+			case "macdef":
+				done()
+				inMacro = true
+				i = len(fields)
+			}
+		}
+	}
+	done()
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading %v: %v", path, err)
+	}
+	return credentials, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/wkumari/sync_telechat/telechat"
+)
+
+// Build information, set with e.g.
+//
+//	go build -ldflags "-X main.version=1.2 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%F)"
+//
+// The version is also used in the default User-Agent.
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+// Sets the User-Agent header on every request.
+type userAgentTransport struct {
+	agent string
+	next  http.RoundTripper
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrippers must not modify the caller's request.
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.agent)
+	return t.next.RoundTrip(req)
+}
+
+// Returns the client used for all our requests, configured from opts.
+func newClient(opts options) (*http.Client, error) {
+	base := http.DefaultTransport.(*http.Transport).Clone()
+
+	// Without --proxy we keep the default, which honors the HTTP_PROXY,
+	// HTTPS_PROXY and NO_PROXY environment variables.
+	if opts.proxy != "" {
+		proxy, err := url.Parse(opts.proxy)
+		if err != nil || proxy.Host == "" {
+			return nil, fmt.Errorf("bad --proxy %q", opts.proxy)
+		}
+		base.Proxy = http.ProxyURL(proxy)
+	}
+
+	if opts.insecureSkipVerify {
+		log.Warn("Not verifying TLS certificates (--insecure-skip-verify)!")
+		base.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	var transport http.RoundTripper = base
+	credentials := make(map[string]credential)
+	if opts.netrc {
+		path, err := telechat.Expand(opts.netrcFile)
+		if err != nil {
+			return nil, err
+		}
+		credentials, err = readNetrc(path, configuredHosts(opts))
+		if err != nil {
+			return nil, fmt.Errorf("error reading --netrc-file: %v", err)
+		}
+		for host := range credentials {
+			log.Infof("Using credentials from %v for %v", path, host)
+		}
+	}
+	// The command line beats netrc.
+	if opts.authUser != "" {
+		host, err := authHost(opts)
+		if err != nil {
+			return nil, err
+		}
+		credentials[host] = credential{opts.authUser, opts.authPass}
+	}
+	if len(credentials) > 0 {
+		transport = &authTransport{credentials, transport}
+	}
+	if opts.userAgent != "" {
+		transport = &userAgentTransport{opts.userAgent, transport}
+	}
+	client := &http.Client{
+		Transport:     transport,
+		CheckRedirect: checkRedirect(opts.maxRedirects, opts.allowCrossHostRedirect),
+	}
+	// For the session cookie, see login. It lasts as long as we do, so with
+	// --watch we only log in once.
+	if opts.login.url != "" {
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			return nil, err
+		}
+		client.Jar = jar
+	}
+	return client, nil
+}
+
+// Returns a CheckRedirect which logs each redirect, and stops after
+// maxRedirects of them, or at one to another host unless allowCrossHost.
+func checkRedirect(maxRedirects int, allowCrossHost bool) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		previous := via[len(via)-1]
+		log.Infof("Redirected from %v to %v", previous.URL, req.URL)
+		if len(via) > maxRedirects {
+			return fmt.Errorf("stopped after %d redirects (--max-redirects)", maxRedirects)
+		}
+		if !allowCrossHost && req.URL.Host != via[0].URL.Host {
+			return fmt.Errorf("not following redirect to another host (%v), see --allow-cross-host-redirect", req.URL.Host)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+	"github.com/wkumari/sync_telechat/telechat"
+	"gopkg.in/yaml.v3"
+)
+
+// Loads settings from the YAML file at path into the flags.
+//
+// The file is a map from (long) flag names to values, e.g.
+//
+//	basedir: ~/telechats
+//	format: [pdf, txt]
+//	parallelism: 4
+//
+// Flags given on the command line (or by loadEnv) take precedence over the
+// file, so only flags which have not already been set are changed. Anything
+// not in either keeps its default.
+func loadConfig(path string) error {
+	path, err := telechat.Expand(path)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading config: %v", err)
+	}
+
+	var settings map[string]interface{}
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return fmt.Errorf("error parsing config %v: %v", path, err)
+	}
+	for name, value := range settings {
+		f := flag.Lookup(name)
+		if f == nil || name == "config" {
+			return fmt.Errorf("unknown setting %q in config %v", name, path)
+		}
+		if f.Changed {
+			continue
+		}
+		if err := flag.Set(name, configValue(value)); err != nil {
+			return fmt.Errorf("bad value for %q in config %v: %v", name, path, err)
+		}
+	}
+	return nil
+}
+
+// Prefix for environment variables which set flags, see loadEnv.
+const kEnvPrefix = "SYNC_TELECHAT_"
+
+// Returns the environment variable for the flag called name, e.g.
+// SYNC_TELECHAT_RETRY_BASE_DELAY for --retry-base-delay.
+func envName(name string) string {
+	return kEnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// Sets any flags not given on the command line from the environment (see
+// envName). Slice flags take comma separated values.
+func loadEnv() error {
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok || f.Changed || err != nil {
+			return
+		}
+		if serr := flag.Set(f.Name, value); serr != nil {
+			err = fmt.Errorf("bad value for %v: %v", envName(f.Name), serr)
+		}
+	})
+	return err
+}
+
+// Converts a value from the config file into a string for flag.Set. Lists
+// become comma separated, which is what the slice flags take, and maps become
+// comma separated key=value pairs (e.g. for --url-template).
+func configValue(value interface{}) string {
+	var items []string
+	switch value := value.(type) {
+	case []interface{}:
+		for _, item := range value {
+			items = append(items, fmt.Sprint(item))
+		}
+	case map[string]interface{}:
+		for key, item := range value {
+			items = append(items, fmt.Sprintf("%v=%v", key, item))
+		}
+		sort.Strings(items)
+	default:
+		return fmt.Sprint(value)
+	}
+	return strings.Join(items, ",")
+}
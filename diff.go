@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/wkumari/sync_telechat/telechat"
+)
+
+// Prints which documents were added, removed or unchanged between the
+// telechats on dateA and dateB, according to their manifests where f would
+// have put them.
+func diffTelechats(w io.Writer, f *telechat.Fetcher, dateA, dateB string) error {
+	a, err := telechat.ReadManifest(f.Dir(dateA), f.Prefix(dateA))
+	if err != nil {
+		return fmt.Errorf("error reading manifest for %v: %v", dateA, err)
+	}
+	b, err := telechat.ReadManifest(f.Dir(dateB), f.Prefix(dateB))
+	if err != nil {
+		return fmt.Errorf("error reading manifest for %v: %v", dateB, err)
+	}
+
+	inA := make(map[string]bool)
+	for _, doc := range a.Documents {
+		inA[doc] = true
+	}
+	inB := make(map[string]bool)
+	for _, doc := range b.Documents {
+		inB[doc] = true
+	}
+
+	var added, removed, unchanged []string
+	for _, doc := range b.Documents {
+		if inA[doc] {
+			unchanged = append(unchanged, doc)
+		} else {
+			added = append(added, doc)
+		}
+	}
+	for _, doc := range a.Documents {
+		if !inB[doc] {
+			removed = append(removed, doc)
+		}
+	}
+
+	for _, group := range []struct {
+		prefix string
+		docs   []string
+	}{{"+", added}, {"-", removed}, {" ", unchanged}} {
+		for _, doc := range group.docs {
+			fmt.Fprintf(w, "%v %v\n", group.prefix, doc)
+		}
+	}
+	return nil
+}
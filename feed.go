@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/wkumari/sync_telechat/telechat"
+)
+
+// The parts of an RSS 2.0 feed we write.
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link,omitempty"`
+	Description string `xml:"description"`
+	GUID        string `xml:"guid"`
+	PubDate     string `xml:"pubDate"`
+}
+
+// Adds an item for each telechat to the RSS feed at path (creating it if
+// needed), keeping only the newest maxItems items.
+func updateFeed(path string, telechats map[string][]telechat.Doc, maxItems int, now time.Time) error {
+	path, err := telechat.Expand(path)
+	if err != nil {
+		return err
+	}
+	feed := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       "IESG telechats",
+			Link:        telechat.JSONURL,
+			Description: "Telechats synced by sync_telechat",
+		},
+	}
+	data, err := ioutil.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := xml.Unmarshal(data, &feed); err != nil {
+			return fmt.Errorf("error reading feed %v: %v", path, err)
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	var dates []string
+	for date := range telechats {
+		dates = append(dates, date)
+	}
+	sort.Strings(dates)
+
+	// Newest first.
+	var items []rssItem
+	for _, date := range dates {
+		var lines []string
+		for _, doc := range telechats[date] {
+			if doc.Name() != "" {
+				lines = append(lines, fmt.Sprintf(`<a href="%v">%v</a>`, telechat.DatatrackerURL(doc), doc.Name()))
+			}
+		}
+		items = append([]rssItem{{
+			Title:       "IESG telechat " + date,
+			Link:        fmt.Sprintf(telechat.DatedJSONURL, date),
+			Description: strings.Join(lines, "<br>\n"),
+			GUID:        fmt.Sprintf("telechat-%v-%v", date, now.Unix()),
+			PubDate:     now.Format(time.RFC1123Z),
+		}}, items...)
+	}
+	feed.Channel.Items = append(items, feed.Channel.Items...)
+	if len(feed.Channel.Items) > maxItems {
+		feed.Channel.Items = feed.Channel.Items[:maxItems]
+	}
+
+	data, err = xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append([]byte(xml.Header), append(data, '\n')...), 0644)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/wkumari/sync_telechat/telechat"
+	_ "modernc.org/sqlite"
+)
+
+const kHistorySchema = `CREATE TABLE IF NOT EXISTS downloads (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	date TEXT NOT NULL,
+	docname TEXT NOT NULL,
+	rev TEXT NOT NULL,
+	format TEXT NOT NULL,
+	url TEXT NOT NULL,
+	bytes INTEGER NOT NULL,
+	status TEXT NOT NULL,
+	error TEXT NOT NULL,
+	timestamp TEXT NOT NULL
+)`
+
+// Records the results of a sync in the SQLite database at path, so the
+// history can be queried across runs (e.g. when did we first see a draft).
+func recordHistory(path string, telechats map[string][]telechat.Doc, results []telechat.Result, now time.Time) error {
+	path, err := telechat.Expand(path)
+	if err != nil {
+		return err
+	}
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if _, err := db.Exec(kHistorySchema); err != nil {
+		return fmt.Errorf("error creating table: %v", err)
+	}
+
+	// Results only carry docname-rev, so map that back to the Doc.
+	docs := make(map[string]telechat.Doc)
+	for date, documents := range telechats {
+		for _, doc := range documents {
+			docs[date+"/"+doc.Name()] = doc
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(`INSERT INTO downloads
+		(date, docname, rev, format, url, bytes, status, error, timestamp)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	stamp := now.UTC().Format(time.RFC3339)
+	for _, result := range results {
+		if result.Doc == "" {
+			continue
+		}
+		doc, ok := docs[result.Date+"/"+result.Doc]
+		if !ok {
+			doc = telechat.Doc{Docname: result.Doc}
+		}
+		entry := telechat.NewManifestEntry(result)
+		if _, err := stmt.Exec(result.Date, doc.Docname, doc.Rev, result.Format,
+			result.URL, result.Bytes, entry.Status, entry.Error, stamp); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("error recording %v: %v", result.Doc, err)
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+	// So we know when 07:00 Pacific is even without the system's zoneinfo.
+	_ "time/tzdata"
+
+	"github.com/wkumari/sync_telechat/telechat"
+)
+
+const (
+	// When telechats usually start, and how long they last.
+	kTelechatZone     = "America/Los_Angeles"
+	kTelechatHour     = 7
+	kTelechatDuration = 2 * time.Hour
+
+	// How iCalendar writes times, in UTC.
+	kICSTimeLayout = "20060102T150405Z"
+)
+
+// Escapes s for an iCalendar text value.
+func icsEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(s)
+}
+
+// Folds an iCalendar content line so no line is longer than 75 octets.
+func icsFold(line string) string {
+	var b strings.Builder
+	// The leading space on continuation lines counts too.
+	limit := 75
+	for len(line) > limit {
+		// Don't split a UTF-8 sequence.
+		cut := limit
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		limit = 74
+	}
+	b.WriteString(line)
+	return b.String()
+}
+
+// Writes an iCalendar file to path with an event for each telechat, listing
+// its documents.
+func writeICS(path string, telechats map[string][]telechat.Doc, now time.Time) error {
+	zone, err := time.LoadLocation(kTelechatZone)
+	if err != nil {
+		return err
+	}
+	var dates []string
+	for date := range telechats {
+		dates = append(dates, date)
+	}
+	sort.Strings(dates)
+
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//wkumari//sync_telechat//EN",
+	}
+	for _, date := range dates {
+		day, err := time.ParseInLocation(telechat.DateLayout, date, zone)
+		if err != nil {
+			return fmt.Errorf("bad telechat date %q: %v", date, err)
+		}
+		start := day.Add(kTelechatHour * time.Hour)
+		var docs []string
+		for _, doc := range telechats[date] {
+			if name := doc.Name(); name != "" {
+				docs = append(docs, name)
+			}
+		}
+		lines = append(lines,
+			"BEGIN:VEVENT",
+			"UID:telechat-"+date+"@sync_telechat",
+			"DTSTAMP:"+now.UTC().Format(kICSTimeLayout),
+			"DTSTART:"+start.UTC().Format(kICSTimeLayout),
+			"DTEND:"+start.Add(kTelechatDuration).UTC().Format(kICSTimeLayout),
+			"SUMMARY:"+icsEscape("IESG telechat "+date),
+			"DESCRIPTION:"+icsEscape(strings.Join(docs, "\n")),
+			"END:VEVENT",
+		)
+	}
+	lines = append(lines, "END:VCALENDAR")
+
+	var b strings.Builder
+	for _, line := range lines {
+		b.WriteString(icsFold(line) + "\r\n")
+	}
+	path, err = telechat.Expand(path)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, []byte(b.String()), 0644)
+}
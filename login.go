@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// How to log in to a datatracker which needs it, see login.
+type loginSettings struct {
+	url      string
+	user     string
+	password string
+}
+
+// The name of the cookie (and form field) Django uses against CSRF.
+const kCSRFName = "csrftoken"
+
+// Logs in to the datatracker (or anything else with a Django style login
+// form) at settings.url, so the session cookie ends up in client's jar and
+// is sent with everything after.
+//
+// We GET the form first, for the CSRF cookie, and then POST the username and
+// password to it. Django redirects away from the form when it works, and shows
+// it again when it doesn't.
+func login(ctx context.Context, client *http.Client, settings loginSettings) error {
+	if client.Jar == nil {
+		return fmt.Errorf("can't log in without a cookie jar")
+	}
+	loginURL, err := url.Parse(settings.url)
+	if err != nil {
+		return fmt.Errorf("bad --login-url %q: %v", settings.url, err)
+	}
+	if _, err := loginRequest(ctx, client, http.MethodGet, settings.url, nil); err != nil {
+		return fmt.Errorf("error getting the login form: %v", err)
+	}
+
+	form := url.Values{"username": {settings.user}, "password": {settings.password}}
+	for _, cookie := range client.Jar.Cookies(loginURL) {
+		if cookie.Name == kCSRFName {
+			form.Set("csrfmiddlewaretoken", cookie.Value)
+		}
+	}
+	final, err := loginRequest(ctx, client, http.MethodPost, settings.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("error logging in: %v", err)
+	}
+	if final.Path == loginURL.Path {
+		return fmt.Errorf("login as %v at %v failed (check --login-user and --login-password)", settings.user, settings.url)
+	}
+	log.Infof("Logged in to %v as %v", loginURL.Host, settings.user)
+	return nil
+}
+
+// Makes a request to the login form, returning the URL we ended up at after
+// any redirects.
+func loginRequest(ctx context.Context, client *http.Client, method string, target string, body io.Reader) (*url.URL, error) {
+	request, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		// Django won't take the POST over https without it.
+		request.Header.Set("Referer", target)
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, response.Body)
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return nil, fmt.Errorf("server returned %v", response.Status)
+	}
+	return response.Request.URL, nil
+}
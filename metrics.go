@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/wkumari/sync_telechat/telechat"
+)
+
+// Writes metrics about a sync (with totals, which failed with err if that's
+// not nil) to path in the Prometheus text format, for the node_exporter
+// textfile collector.
+func writeMetrics(path string, totals summary, err error, now time.Time) error {
+	path, xerr := telechat.Expand(path)
+	if xerr != nil {
+		return xerr
+	}
+	success := 1
+	if err != nil {
+		success = 0
+	}
+
+	var b strings.Builder
+	metric := func(name string, kind string, help string, value interface{}) {
+		fmt.Fprintf(&b, "# HELP sync_telechat_%v %v\n", name, help)
+		fmt.Fprintf(&b, "# TYPE sync_telechat_%v %v\n", name, kind)
+		fmt.Fprintf(&b, "sync_telechat_%v %v\n", name, value)
+	}
+	metric("documents_downloaded", "gauge", "Documents downloaded by the last sync.", totals.downloaded)
+	metric("documents_skipped", "gauge", "Documents skipped (already there, linked or too large) by the last sync.", totals.skipped)
+	metric("documents_failed", "gauge", "Documents which failed to download in the last sync.", totals.failed)
+	metric("bytes_downloaded", "gauge", "Bytes transferred by the last sync.", totals.bytes)
+	metric("last_run_success", "gauge", "Whether the last sync got the agenda and ran to the end (1) or not (0).", success)
+	metric("last_run_timestamp_seconds", "gauge", "When the last sync finished, in seconds since the epoch.", now.Unix())
+
+	// The collector may read the file at any time, so replace it in one go.
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(b.String()), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Writes the metrics for a sync to --metrics-file, if we have one. Dry runs
+// don't count.
+func recordMetrics(totals summary, err error) {
+	if opts.metricsFile == "" || opts.dryRun {
+		return
+	}
+	if err := writeMetrics(opts.metricsFile, totals, err, time.Now()); err != nil {
+		log.Errorf("Error writing %v: %v", opts.metricsFile, err)
+	}
+}
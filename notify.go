@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/wkumari/sync_telechat/telechat"
+)
+
+// How long to wait for a notification to be accepted.
+const kNotifyTimeout = 10 * time.Second
+
+// Returns the documents in results which were actually downloaded this time
+// (not skipped, linked, copied, too large or failed), by date, and the dates in order.
+// Writeups and ballots aren't documents (and ballots are fetched every time).
+func newDownloads(results []telechat.Result) (map[string][]telechat.Result, []string) {
+	byDate := make(map[string][]telechat.Result)
+	var dates []string
+	for _, result := range results {
+		if result.Err != nil || result.Skipped || result.LinkedFrom != "" || result.CopiedFrom != "" || result.TooLarge || result.Missing {
+			continue
+		}
+		if !telechat.KnownFormat(result.Format) {
+			continue
+		}
+		if _, ok := byDate[result.Date]; !ok {
+			dates = append(dates, result.Date)
+		}
+		byDate[result.Date] = append(byDate[result.Date], result)
+	}
+	sort.Strings(dates)
+	return byDate, dates
+}
+
+// The JSON body we POST to --webhook-url.
+type webhookPayload struct {
+	Date      string                   `json:"telechat-date"`
+	Documents []telechat.ManifestEntry `json:"documents"`
+}
+
+// POSTs body as JSON to url, failing if it isn't accepted quickly.
+func postJSON(ctx context.Context, client *http.Client, url string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, kNotifyTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("server returned %v", resp.Status)
+	}
+	return nil
+}
+
+// POSTs a webhookPayload to url for each telechat which had new documents
+// downloaded. Failures are logged, but otherwise ignored.
+func notifyWebhook(ctx context.Context, client *http.Client, url string, results []telechat.Result) {
+	byDate, dates := newDownloads(results)
+	for _, date := range dates {
+		payload := webhookPayload{Date: date}
+		for _, result := range byDate[date] {
+			payload.Documents = append(payload.Documents, telechat.NewManifestEntry(result))
+		}
+		if err := postJSON(ctx, client, url, payload); err != nil {
+			log.Warnf("Error calling webhook for %v: %v", date, err)
+		}
+	}
+}
+
+// Where and how to send email digests.
+type smtpSettings struct {
+	host string // host:port
+	from string
+	to   []string
+	// Optional, for servers which want us to log in.
+	user     string
+	password string
+}
+
+// The digest email, given the date and its new documents.
+var emailDigest = template.Must(template.New("email").Parse(`From: {{.From}}
+To: {{.To}}
+Date: {{.Sent}}
+Message-ID: {{.MessageID}}
+Subject: IESG telechat {{.Date}}: {{len .Documents}} new document(s)
+Content-Type: text/plain; charset=utf-8
+
+New documents for the IESG telechat on {{.Date}}:
+{{range .Documents}}
+  {{.Doc}} ({{.Format}}): {{.Bytes}} bytes
+{{- end}}
+`))
+
+// Returns a new Message-ID for an email from from (an address, or
+// "Name <address>"), e.g. <1718236800.1f2e3d4c5b6a7988@example.com>.
+func messageID(from string) string {
+	var random [8]byte
+	rand.Read(random[:])
+	domain := "localhost"
+	if at := strings.LastIndex(from, "@"); at >= 0 {
+		domain = strings.TrimRight(from[at+1:], "> ")
+	}
+	return fmt.Sprintf("<%d.%x@%v>", time.Now().Unix(), random, domain)
+}
+
+// Emails a digest of the new documents in results, one per telechat.
+// Failures are logged, but otherwise ignored.
+func notifyEmail(settings smtpSettings, results []telechat.Result) {
+	var auth smtp.Auth
+	if settings.user != "" {
+		host, _, _ := net.SplitHostPort(settings.host)
+		auth = smtp.PlainAuth("", settings.user, settings.password, host)
+	}
+
+	byDate, dates := newDownloads(results)
+	for _, date := range dates {
+		var b bytes.Buffer
+		err := emailDigest.Execute(&b, struct {
+			From, To, Sent, MessageID, Date string
+			Documents                       []telechat.Result
+		}{settings.from, strings.Join(settings.to, ", "), time.Now().Format(time.RFC1123Z),
+			messageID(settings.from), date, byDate[date]})
+		if err == nil {
+			// SMTP wants CRLF line endings.
+			body := strings.ReplaceAll(b.String(), "\n", "\r\n")
+			err = smtp.SendMail(settings.host, auth, settings.from, settings.to, []byte(body))
+		}
+		if err != nil {
+			log.Warnf("Error emailing digest for %v: %v", date, err)
+		}
+	}
+}
+
+// Posts a message to the Slack incoming webhook at url for each telechat in
+// telechats which had new documents downloaded, linking to the datatracker
+// page of each of its documents. Failures are logged, but otherwise ignored.
+func notifySlack(ctx context.Context, client *http.Client, url string, telechats map[string][]telechat.Doc, results []telechat.Result) {
+	byDate, dates := newDownloads(results)
+	for _, date := range dates {
+		var docs []telechat.Doc
+		for _, doc := range telechats[date] {
+			if doc.Kind != telechat.KindManagement {
+				docs = append(docs, doc)
+			}
+		}
+		var b strings.Builder
+		fmt.Fprintf(&b, "*IESG telechat %v*: %d document(s), %d new download(s)\n",
+			date, len(docs), len(byDate[date]))
+		for _, doc := range docs {
+			fmt.Fprintf(&b, "• <%v|%v>\n", telechat.DatatrackerURL(doc), doc.Name())
+		}
+		if err := postJSON(ctx, client, url, map[string]string{"text": b.String()}); err != nil {
+			log.Warnf("Error posting to Slack for %v: %v", date, err)
+		}
+	}
+}
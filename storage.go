@@ -0,0 +1,18 @@
+package main
+
+import (
+	"os"
+
+	"github.com/wkumari/sync_telechat/telechat"
+)
+
+// Returns the storage for the documents under basedir, configured from opts:
+// with no --dest the local disk, or for s3://bucket/prefix that bucket (see
+// telechat.NewS3Storage).
+func newStorage(opts options, basedir string) (telechat.Storage, error) {
+	local := telechat.LocalStorage{FileMode: os.FileMode(opts.fileMode)}
+	if opts.dest == "" {
+		return local, nil
+	}
+	return telechat.NewS3Storage(opts.dest, opts.s3Endpoint, opts.s3Region, basedir, local)
+}
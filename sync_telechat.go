@@ -1,187 +1,429 @@
 // This program read the upcoming IESG telechats, downloads PDF versions
 // of each new document, and places them in directories according to the date.
+// The work is done by the telechat package, this is its command line.
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"net/http"
 	"os"
-	"os/user"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
-	"github.com/golang/glog"
 	log "github.com/sirupsen/logrus"
 	flag "github.com/spf13/pflag"
-)
-
-const (
-	// I am using leading k for constants, in violation of GoLint
-	// Where the JSON version of the IESG agenda lives.
-	kJSONURL = "https://datatracker.ietf.org/iesg/agenda/agenda.json"
-
-	// Where the PDF versions of drafts live.
-	kDocURL = "https://tools.ietf.org/pdf/"
+	"github.com/wkumari/sync_telechat/telechat"
+	"golang.org/x/time/rate"
 )
 
 type options struct {
+	// Just print the build information.
+	version bool
+	// YAML file to read settings from, see loadConfig.
+	config  string
 	basedir string
-	baseurl string
+	// Create basedir (and its parents) if it doesn't exist.
+	createBasedir bool
+	// Store the documents here (s3://bucket/prefix) rather than in basedir,
+	// see newStorage.
+	dest       string
+	s3Endpoint string
+	s3Region   string
+	// How to compress the documents we store, "" for not at all.
+	compress string
+	// Permissions for the directories and documents we create.
+	dirMode  modeValue
+	fileMode modeValue
+	baseurl  string
+	// Fetch the agenda of this telechat rather than the next one.
+	date string
+	// Or of all the telechats in this range.
+	from string
+	to   string
+	// Where to get the documents from, rather than the IETF servers.
+	docBaseURL string
+	// How to name the date directories, see dateDir.
+	dateLayout string
+	// Or put everything in basedir, with date-prefixed names.
+	flat bool
+	// Or a URL for each format, with telechat.NamePlaceholder for the document.
+	urlTemplates map[string]string
+	// Base URLs to try, in order, when the usual place fails.
+	mirrors []string
+	formats []string
+	timeout time.Duration
+	// Maximum number of downloads in flight at once for each telechat, and
+	// of telechats at once.
+	parallelism     int
+	dateParallelism int
+	// How many times to retry a failed download, and how long to wait
+	// before the first retry (this doubles for each subsequent retry).
+	retries    int
+	retryDelay time.Duration
+	// Re-download documents even if we already have them.
+	overwrite bool
+	// Re-download documents the server's HEAD responses say have changed.
+	checkHead bool
+	// Re-download documents which don't match their stored checksums.
+	verify bool
+	// Run this on each downloaded document, see telechat.Fetcher.PostHook. With
+	// hookStrict, one failing fails the sync.
+	postHook    string
+	hookTimeout time.Duration
+	hookStrict  bool
+	// Don't download documents bigger than this many bytes (0 for no limit).
+	maxSize int64
+	// Only print the telechat dates on stdout.
+	printDates bool
+	// Only print what would be downloaded.
+	dryRun bool
+	// Also include management items.
+	includeManagement bool
+	// Download RFCs on the agenda too.
+	includeRFCs bool
+	// And each draft's shepherd writeup, and ballot positions.
+	includeWriteup bool
+	includeBallot  bool
+	// Hardlink documents from other telechats' directories when we can.
+	hardlink bool
+	// Or copy them.
+	noClobber bool
+	// Show progress, if stdout is a terminal.
+	progress bool
+	// Only download the documents of these ADs, with these intended statuses.
+	ads      []string
+	statuses []string
+	// Only download documents whose names match nameFilter, and don't match
+	// nameExclude (regexps, compiled into the *RE fields).
+	nameFilter    string
+	nameExclude   string
+	nameFilterRE  *regexp.Regexp
+	nameExcludeRE *regexp.Regexp
+	// How to print the results, "text" or "json".
+	output string
+	// Only print failures (and the summary).
+	quiet bool
+	// Keep running, syncing every pollInterval.
+	watch        bool
+	pollInterval time.Duration
+	// Abandon each sync which takes longer than this (0 means never), see
+	// syncOnce.
+	maxRuntime time.Duration
+	// Told about newly downloaded documents.
+	webhookURL   string
+	smtp         smtpSettings
+	slackWebhook string
+	// Write a calendar entry for the telechat(s) here.
+	icsOutput string
+	// Add an item per telechat to this RSS feed, which keeps feedItems.
+	feedOutput string
+	feedItems  int
+	// Put all the PDFs into one here.
+	mergePDF string
+	// Record every download in this SQLite database.
+	db string
+	// Write Prometheus metrics about each sync here.
+	metricsFile string
+	// Log in here first, for datatrackers which need it.
+	login loginSettings
+	// Basic auth for requests to authHost (see authHost).
+	authUser string
+	authPass string
+	authHost string
+	// Or basic auth from this netrc file, for any of the hosts we use.
+	netrc     bool
+	netrcFile string
+	// Sent with every request.
+	userAgent string
+	// Proxy URL to use instead of the one from the environment (if any).
+	proxy string
+	// Maximum document requests per second, 0 for no limit.
+	rateLimit float64
+	// Don't check TLS certificates, for mirrors with self-signed ones.
+	insecureSkipVerify bool
+	// How many redirects to follow, and whether they may go to another host.
+	maxRedirects           int
+	allowCrossHostRedirect bool
 
 	debug   bool
 	verbose bool
+	// Remove telechat directories older than this (see telechat.ParseAge), and
+	// that parsed (0 means don't).
+	pruneOlderThan string
+	pruneAge       time.Duration
+
+	// Also write logs here.
+	logFile string
+	// "text" or "json".
+	logFormat string
 }
 
 var (
 	opts = options{}
 )
 
-// Expand home directory. Only works on Unix type systems.
-// No sure why Go doesn't include a helper for this (well, I am, I just don't
-// agree :-) )
-func expand(path string) (string, error) {
-	if len(path) == 0 || !strings.HasPrefix(path, "~") {
-		return path, nil
-	}
+// Default User-Agent, given our version.
+const kUserAgent = "sync_telechat/%s (+https://github.com/wkumari/sync_telechat)"
 
-	u, err := user.Current()
-	if err != nil {
-		return "", err
+// A flag holding file permissions, in octal.
+type modeValue os.FileMode
+
+func (m *modeValue) Set(s string) error {
+	n, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || n > 0777 {
+		return fmt.Errorf("%q is not an octal mode like 0755", s)
 	}
-	return filepath.Join(u.HomeDir, path[1:]), nil
+	*m = modeValue(n)
+	return nil
 }
 
-// Fetches a single document, puts it in the directory specified by date.
-// Notifies we are done by posting to done!
-func fetchDoc(basedir string, date string, document string, done chan string) {
+func (m *modeValue) String() string {
+	return fmt.Sprintf("%#o", uint32(*m))
+}
 
-	filename := document + ".pdf"
-	url := kDocURL + filename
+func (m *modeValue) Type() string {
+	return "mode"
+}
 
-	// If this fails because the file already exists, we are done!
-	fullname := filepath.Join(basedir, date, filename)
-	var _, err = os.Stat(fullname)
-	if err == nil {
-		done <- fmt.Sprintf("%v: %v already existed.", date, filename)
-		return
-	}
-	output, err := os.Create(fullname)
-	if err != nil {
-		done <- fmt.Sprintf("Error creating %v: %v", fullname, err.Error())
-		return
-	}
-	defer output.Close()
+// Totals over a run's results.
+type summary struct {
+	downloaded int
+	skipped    int // Including hardlinked and copied ones.
+	failed     int
+	bytes      int64 // Transferred, so not counting skipped documents.
+}
 
-	response, err := http.Get(url)
-	if err != nil {
-		done <- fmt.Sprintf("Error while downloading %v-%v", url, err.Error())
-		return
+// Adds up results.
+func summarize(results []telechat.Result) summary {
+	var s summary
+	for _, result := range results {
+		switch {
+		case result.Err != nil:
+			s.failed++
+		case result.Skipped || result.LinkedFrom != "" || result.CopiedFrom != "" || result.TooLarge || result.Missing:
+			s.skipped++
+		default:
+			s.downloaded++
+			s.bytes += result.Received
+		}
 	}
-	defer response.Body.Close()
+	return s
+}
+
+func (s summary) String() string {
+	return fmt.Sprintf("%d downloaded, %d skipped, %d failed, %d bytes transferred.",
+		s.downloaded, s.skipped, s.failed, s.bytes)
+}
 
-	n, err := io.Copy(output, response.Body)
+// Writes results to w as a JSON array.
+func printJSONResults(w io.Writer, results []telechat.Result) error {
+	type jsonResult struct {
+		Date string `json:"date"`
+		telechat.ManifestEntry
+	}
+	items := []jsonResult{}
+	for _, result := range results {
+		items = append(items, jsonResult{result.Date, telechat.NewManifestEntry(result)})
+	}
+	data, err := json.MarshalIndent(items, "", "  ")
 	if err != nil {
-		done <- fmt.Sprintf("Error while downloading: %v - %v ", url, err.Error())
-		return
+		return err
 	}
-	done <- fmt.Sprintf("%v: Downloaded %s: %d bytes.", date, filename, n)
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
 }
 
-// Fetches documents in parallel.
-//
-// Takes a map of slices, {"date": [doc1, doc2]} and
-// gets the documents.
-func fetchDocs(basedir string, documents map[string][]string) []string {
+// Reports whether file is a terminal (well, a character device, which is
+// close enough).
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
 
-	// Make directories if not already exist
-	for date := range documents {
-		err := os.Mkdir(filepath.Join(basedir, date), 0777)
-		if err != nil && !os.IsExist(err) {
-			glog.Fatal(fmt.Sprintf("Error making %v: %v", filepath.Join(basedir, date), err.Error()))
-		}
+// Prints dates, sorted, one per line, for --print-dates.
+func printDates(w io.Writer, dates []string) {
+	sorted := append([]string(nil), dates...)
+	sort.Strings(sorted)
+	for _, date := range sorted {
+		fmt.Fprintln(w, date)
 	}
+}
 
-	// Channels
-	doccount := 0
-	var items []string
-	channel := make(chan string)
-	for date := range documents {
-		for _, document := range documents[date] {
-			go fetchDoc(basedir, date, document, channel)
-			doccount++
-		}
+// Does one sync: fetches the agenda and downloads the documents on it into
+// f.BaseDir (or with --dry-run, says what it would download), then prints
+// the results.
+// With --max-runtime, fetching the agenda and the documents is abandoned
+// once it has taken that long, which fails the sync. The documents which
+// didn't finish are reported as failures, and everything after (the indexes,
+// notifications and so on) is still done with what we got.
+func syncOnce(ctx context.Context, f *telechat.Fetcher) (summary, error) {
+	runCtx := ctx
+	if opts.maxRuntime > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, opts.maxRuntime)
+		defer cancel()
 	}
-	for len(items) < doccount {
-		select {
-		case downloaded := <-channel:
-			items = append(items, downloaded)
-
-		case <-time.After(time.Second * 10):
-			items = append(items, "Timeout downloading a draft....")
+	sources := []string{opts.baseurl}
+	if opts.from != "" {
+		dates, err := telechat.TelechatDates(runCtx, f.Client, opts.from, opts.to)
+		if err != nil {
+			return summary{}, err
+		}
+		if len(dates) == 0 {
+			log.Warnf("There are no telechats from %v to %v", opts.from, opts.to)
 		}
+		sources = telechat.DatedAgendas(dates)
 	}
-	close(channel)
-	return items
-}
-
-func fetchAgenda(url string) (map[string][]string, error) {
-	result := make(map[string][]string)
-
-	var agenda map[string]interface{}
-	var sections map[string]interface{}
-
-	resp, err := http.Get(url)
+	telechats, raws, err := telechat.FetchAgendas(runCtx, f.Client, sources, opts.includeManagement)
 	if err != nil {
-		log.Error("ERROR: " + err.Error())
-		return result, fmt.Errorf("error fetching agenda: %v", err)
+		return summary{}, err
 	}
-
-	if resp.Body != nil {
-		defer resp.Body.Close()
+	// Agendas are sometimes posted before anything is on them, which is fine.
+	listed := telechat.CountDownloadable(telechats)
+	for date, count := range listed {
+		if count == 0 {
+			log.Warnf("Telechat %v has no documents yet", date)
+		}
+	}
+	if len(opts.ads) > 0 {
+		telechats = telechat.FilterDocs(telechats, telechat.ADFilter(opts.ads))
+	}
+	if len(opts.statuses) > 0 {
+		telechats = telechat.FilterDocs(telechats, telechat.StatusFilter(opts.statuses))
+	}
+	if opts.nameFilterRE != nil {
+		telechats = telechat.FilterDocs(telechats, telechat.NameFilter(opts.nameFilterRE, false))
 	}
+	if opts.nameExcludeRE != nil {
+		telechats = telechat.FilterDocs(telechats, telechat.NameFilter(opts.nameExcludeRE, true))
+	}
+	for date, count := range telechat.CountDownloadable(telechats) {
+		if count == 0 && listed[date] > 0 {
+			log.Warnf("None of the %d document(s) on the %v telechat match the --ad, --status and --name-* filters", listed[date], date)
+		}
+	}
+	log.Infof("Telechats: %v", telechats)
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Error(err)
-		return result, fmt.Errorf("error reading agenda: %v", err)
+	if opts.dryRun && opts.printDates {
+		var dates []string
+		for date := range telechats {
+			dates = append(dates, date)
+		}
+		printDates(os.Stdout, dates)
+		return summary{}, nil
+	}
+	if opts.dryRun {
+		f.DryRun(os.Stdout, telechats)
+		if opts.pruneAge > 0 {
+			if err := f.Prune(opts.pruneAge, true); err != nil {
+				log.Errorf("Error pruning: %v", err)
+			}
+		}
+		return summary{}, nil
 	}
 
-	err = json.Unmarshal(body, &agenda)
-	if err != nil {
-		log.Errorf("Error unmarshalling agenda: %v (%v)", err, string(body))
-		return result, fmt.Errorf("error unmarshalling agenda: %v", err)
-	}
-
-	date := agenda["telechat-date"].(string)
-	sections = agenda["sections"].(map[string]interface{})
-	for section := range sections {
-		content := sections[section].(map[string]interface{})
-		if content["docs"] != nil {
-			docs := content["docs"]
-			if len(docs.([]interface{})) > 0 {
-				for _, doc := range docs.([]interface{}) {
-					doc := doc.(map[string]interface{})
-					docname := doc["docname"].(string)
-					rev, ok := doc["rev"].(string)
-					if !ok {
-						rev = ""
-						log.Debugf("No revision for %v, setting to \"\"", docname)
-					}
-					log.Debugf("Doc: %s (date: %s)", docname, date)
-					result[date] = append(result[date], docname+"-"+rev)
-				}
+	// Carry on with whatever we could do, and report the error at the end.
+	results, fetchErr := f.FetchDocs(runCtx, telechats)
+	if opts.hookStrict {
+		for _, result := range results {
+			if result.HookErr != nil {
+				return summarize(results), fmt.Errorf("post-hook failed for %v (%v), stopping (--hook-strict): %v",
+					result.Doc, result.Format, result.HookErr)
 			}
 		}
 	}
-	return result, nil
+	var dates []string
+	for date := range telechats {
+		dir := f.Dir(date)
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			continue // FetchDocs couldn't make it.
+		}
+		dates = append(dates, date)
+		// So we know exactly what the documents came from.
+		path := filepath.Join(dir, f.Prefix(date)+telechat.AgendaName)
+		if err := ioutil.WriteFile(path, raws[date], 0644); err != nil {
+			log.Errorf("Error saving the agenda: %v", err)
+		}
+	}
+	// In one flat directory there's nothing to point at.
+	if !f.Flat {
+		if err := telechat.UpdateLatest(f.BaseDir, f.DateLayout, dates); err != nil {
+			log.Errorf("Error updating %v: %v", telechat.LatestName, err)
+		}
+	}
+	if opts.icsOutput != "" {
+		if err := writeICS(opts.icsOutput, telechats, time.Now()); err != nil {
+			log.Errorf("Error writing %v: %v", opts.icsOutput, err)
+		}
+	}
+	if opts.mergePDF != "" {
+		if err := f.MergePDF(ctx, opts.mergePDF, telechats, results); err != nil {
+			log.Errorf("Error writing %v: %v", opts.mergePDF, err)
+		}
+	}
+	if opts.feedOutput != "" {
+		if err := updateFeed(opts.feedOutput, telechats, opts.feedItems, time.Now()); err != nil {
+			log.Errorf("Error updating %v: %v", opts.feedOutput, err)
+		}
+	}
+	if opts.db != "" {
+		if err := recordHistory(opts.db, telechats, results, time.Now()); err != nil {
+			log.Errorf("Error recording history in %v: %v", opts.db, err)
+		}
+	}
+	if opts.pruneAge > 0 {
+		if err := f.Prune(opts.pruneAge, false); err != nil {
+			log.Errorf("Error pruning: %v", err)
+		}
+	}
+
+	for _, result := range results {
+		if opts.output == "text" && !opts.printDates && (result.Err != nil || !opts.quiet) {
+			fmt.Printf("%v\n", telechat.FormatResult(result))
+		}
+	}
+	if opts.output == "json" && !opts.printDates {
+		if err := printJSONResults(os.Stdout, results); err != nil {
+			log.Errorf("Error printing results: %v", err)
+		}
+	}
+	if opts.printDates {
+		printDates(os.Stdout, dates)
+	}
+	totals := summarize(results)
+	fmt.Fprintf(os.Stderr, "%v\n", totals)
+	if runCtx.Err() == context.DeadlineExceeded && fetchErr == nil {
+		fetchErr = fmt.Errorf("stopped after --max-runtime (%v), %d download(s) failed or didn't finish",
+			opts.maxRuntime, totals.failed)
+	}
+
+	if opts.webhookURL != "" {
+		notifyWebhook(ctx, f.Client, opts.webhookURL, results)
+	}
+	if opts.smtp.host != "" {
+		notifyEmail(opts.smtp, results)
+	}
+	if opts.slackWebhook != "" {
+		notifySlack(ctx, f.Client, opts.slackWebhook, telechats, results)
+	}
+	return totals, fetchErr
 }
 
 func usage() {
-	fmt.Fprintf(os.Stderr, "Syncs the IESG Telechat to local directories.\n")
+	fmt.Fprintf(os.Stderr, "Syncs the IESG Telechat (or another agenda, see --agenda) to local directories.\n")
+	fmt.Fprintf(os.Stderr, "Flags override %vFLAG_NAME environment variables, which override\n", kEnvPrefix)
+	fmt.Fprintf(os.Stderr, "settings in the --config file, which override the defaults.\n\n")
+	fmt.Fprintf(os.Stderr, "Usage: %v [flags]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %v [flags] diff <dateA> <dateB>   (compare two synced telechats)\n\n", os.Args[0])
 	flag.PrintDefaults()
 	os.Exit(2)
 }
@@ -191,25 +433,380 @@ func parseFlags() {
 	log.SetLevel(log.WarnLevel)
 
 	// Flags:  long name, short name, default value, description
+	flag.BoolVar(&opts.version, "version", false,
+		"Print the version and build information, and exit.")
+	flag.StringVar(&opts.config, "config", "",
+		"YAML file of settings, keyed by flag name.")
 	flag.StringVar(&opts.basedir, "basedir", "",
 		"Base directory to put files. Makes date based directories here.")
-	flag.StringVar(&opts.baseurl, "agenda", kJSONURL,
-		"Where the agenda lives")
+	flag.BoolVar(&opts.createBasedir, "create-basedir", false,
+		"Create the base directory if it doesn't exist.")
+	flag.StringVar(&opts.dest, "dest", "",
+		"Store the documents in this S3 bucket (s3://bucket/prefix) rather than --basedir, which still gets the manifests, indexes etc.")
+	flag.StringVar(&opts.s3Endpoint, "s3-endpoint", "s3.amazonaws.com",
+		"S3 server for --dest (use an http:// URL for one without TLS). Credentials come from AWS_ACCESS_KEY_ID etc. or ~/.aws/credentials.")
+	flag.StringVar(&opts.s3Region, "s3-region", "us-east-1", "Region of the --dest bucket.")
+	flag.StringVar(&opts.compress, "compress", "",
+		"Compress the documents we store: gzip, which adds "+telechat.GzipSuffix+" to their names. Downloads can't be resumed with it.")
+	opts.dirMode, opts.fileMode = 0755, 0644
+	flag.Var(&opts.dirMode, "dir-mode", "Permissions (octal) for the directories we create.")
+	flag.Var(&opts.fileMode, "file-mode", "Permissions (octal) for the documents we download.")
+	flag.StringVar(&opts.baseurl, "agenda", telechat.JSONURL,
+		"Where the agenda lives: a URL, a local file, or - for stdin")
+	flag.StringVar(&opts.docBaseURL, "doc-base-url", "",
+		"Download documents from here (e.g. for IAB or IRTF agendas), rather than the IETF servers.")
+	flag.StringSliceVar(&opts.mirrors, "mirror", nil,
+		"Base URL(s) to try in turn when a document can't be downloaded from the usual place, comma separated.")
+	flag.StringToStringVar(&opts.urlTemplates, "url-template", nil,
+		"Download documents in a format from this URL, with "+telechat.NamePlaceholder+" for the document, e.g. pdf=https://mirror/pdf/"+telechat.NamePlaceholder+".pdf. Comma separated.")
+	flag.StringVar(&opts.date, "date", "",
+		"Sync the telechat on this date (YYYY-MM-DD) instead of the upcoming one.")
+	flag.StringVar(&opts.dateLayout, "date-layout", telechat.DateLayout,
+		"How to name the date directories, as a Go time layout, e.g. 20060102, or 2006/01/02 for nested directories.")
+	flag.BoolVar(&opts.flat, "flat", false,
+		"Put all the files straight into --basedir, named like 2024-06-13-draft-foo-03.pdf, rather than in date directories.")
+	flag.StringVar(&opts.from, "from", "",
+		"Sync every telechat from this date (YYYY-MM-DD) to --to, each into its own directory.")
+	flag.StringVar(&opts.to, "to", "",
+		"The last date for --from (default today).")
+	flag.StringSliceVar(&opts.formats, "format", []string{"pdf"},
+		"Document format(s) to download, comma separated ("+strings.Join(telechat.FormatNames(), ", ")+").")
+
+	flag.DurationVar(&opts.timeout, "timeout", time.Minute,
+		"How long each request for a document may take before it is abandoned (and retried).")
+
+	flag.IntVar(&opts.parallelism, "parallelism", 8,
+		"Maximum number of documents to download at once, for each telechat.")
+	flag.IntVar(&opts.dateParallelism, "date-parallelism", 2,
+		"Maximum number of telechats to download at once.")
+	flag.BoolVar(&opts.overwrite, "overwrite", false,
+		"Check documents we already have with the server again, replacing our copies of any which it says have changed.")
+	flag.BoolVar(&opts.checkHead, "check-head", false,
+		"Check documents we already have with a HEAD request, and download them again if their size or modification time has changed.")
+	flag.StringVar(&opts.postHook, "post-hook", "",
+		"Shell command to run on each downloaded document, given its path (and TELECHAT_DATE, TELECHAT_DOC, TELECHAT_FORMAT and TELECHAT_FILE in the environment).")
+	flag.DurationVar(&opts.hookTimeout, "hook-timeout", time.Minute,
+		"How long each --post-hook may run before it is killed.")
+	flag.BoolVar(&opts.hookStrict, "hook-strict", false,
+		"Fail the whole sync if a --post-hook fails, rather than just noting it in the results.")
+	flag.BoolVar(&opts.verify, "verify", false,
+		"Check documents we already have against "+telechat.SumsName+", and download any that don't match again.")
+	flag.StringSliceVar(&opts.ads, "ad", nil,
+		"Only download documents with these responsible AD(s), comma separated.")
+	flag.StringSliceVar(&opts.statuses, "status", nil,
+		"Only download documents with these intended status(es), comma separated, e.g. \"Proposed Standard\".")
+	flag.StringVar(&opts.nameFilter, "name-filter", "",
+		"Only download documents whose names match this regexp, e.g. ^draft-ietf-dnsop-")
+	flag.StringVar(&opts.nameExclude, "name-exclude", "",
+		"Don't download documents whose names match this regexp.")
+	flag.BoolVar(&opts.includeManagement, "include-management", false,
+		"Also list management items in the manifest and indexes.")
+	flag.BoolVar(&opts.includeRFCs, "include-rfcs", false,
+		"Also download RFCs on the agenda, not just drafts.")
+	flag.BoolVar(&opts.includeWriteup, "include-writeup", false,
+		"Also download each draft's shepherd writeup from the datatracker, as <doc>-<rev>.writeup.txt.")
+	flag.BoolVar(&opts.includeBallot, "include-ballot", false,
+		"Also download the ballot positions on each draft from the datatracker (every run, as they change), as <doc>-<rev>.ballot.json.")
+	flag.BoolVar(&opts.hardlink, "hardlink", false,
+		"Hardlink documents already downloaded for another telechat, rather than downloading them again.")
+	flag.BoolVar(&opts.noClobber, "no-clobber-across-dates", false,
+		"Copy documents already downloaded for another telechat, rather than downloading them again (with --hardlink, only when they can't be linked).")
+	flag.BoolVar(&opts.progress, "progress", false,
+		"Show progress on stderr as documents are downloaded (only if stdout is a terminal).")
+	flag.BoolVar(&opts.watch, "watch", false,
+		"Keep running, re-syncing every --poll-interval, until interrupted.")
+	flag.DurationVar(&opts.pollInterval, "poll-interval", time.Hour,
+		"How often to re-sync with --watch.")
+	flag.DurationVar(&opts.maxRuntime, "max-runtime", 0,
+		"Abandon the sync (each one, with --watch) if it takes longer than this, e.g. 50m, and fail. 0 for no limit.")
+	flag.StringVar(&opts.webhookURL, "webhook-url", "",
+		"POST a JSON list of newly downloaded documents here after each sync.")
+	flag.StringVar(&opts.icsOutput, "ics-output", "",
+		"Write an iCalendar file with an event for each telechat here.")
+	flag.StringVar(&opts.db, "db", "",
+		"Record every download in the SQLite database at this path.")
+	flag.StringVar(&opts.metricsFile, "metrics-file", "",
+		"After each sync, write Prometheus metrics about it here (e.g. for the node_exporter textfile collector).")
+	flag.StringVar(&opts.mergePDF, "merge-pdf", "",
+		"Also put all the PDFs we have for the telechat(s) into one file here, in agenda order (for reading offline). Needs --format pdf.")
+	flag.StringVar(&opts.feedOutput, "feed-output", "",
+		"Add an item for each synced telechat to the RSS feed here.")
+	flag.IntVar(&opts.feedItems, "feed-items", 20,
+		"How many items to keep in the --feed-output feed.")
+	flag.StringVar(&opts.slackWebhook, "slack-webhook", "",
+		"Post a summary to this Slack webhook after each sync which downloads new documents.")
+	flag.StringVar(&opts.smtp.host, "smtp-host", "",
+		"Email a digest of newly downloaded documents via this SMTP server (host:port).")
+	flag.StringVar(&opts.smtp.from, "smtp-from", "", "Address to send the digest from.")
+	flag.StringSliceVar(&opts.smtp.to, "smtp-to", nil, "Address(es) to send the digest to, comma separated.")
+	flag.StringVar(&opts.smtp.user, "smtp-user", "", "User to log in to the SMTP server as, if it needs it.")
+	flag.StringVar(&opts.smtp.password, "smtp-password", "", "Password for --smtp-user.")
+	flag.BoolVar(&opts.dryRun, "dry-run", false,
+		"Print what would be downloaded, but don't download anything.")
+	flag.Int64Var(&opts.maxSize, "max-size", 0,
+		"Don't download documents bigger than this many bytes (0 for no limit). They are still listed in the manifest.")
+	flag.BoolVar(&opts.printDates, "print-dates", false,
+		"Print only the date(s) of the telechat(s) synced on stdout, one per line, e.g. for scripts.")
+	flag.StringVar(&opts.userAgent, "user-agent", fmt.Sprintf(kUserAgent, version),
+		"User-Agent to send with each request.")
+	flag.StringVar(&opts.proxy, "proxy", "",
+		"Proxy URL for all requests, overriding HTTP_PROXY etc.")
+	flag.StringVar(&opts.login.url, "login-url", "",
+		"Log in with this (Django style) login form before syncing, for datatrackers which need it.")
+	flag.StringVar(&opts.login.user, "login-user", "", "User to log in as with --login-url.")
+	flag.StringVar(&opts.login.password, "login-password", "",
+		"Password for --login-user (better set with "+envName("login-password")+").")
+	flag.StringVar(&opts.authUser, "auth-user", "",
+		"User for basic auth to --auth-host, e.g. for a mirror which needs it.")
+	flag.StringVar(&opts.authPass, "auth-pass", "",
+		"Password for --auth-user (better set with "+envName("auth-pass")+").")
+	flag.StringVar(&opts.authHost, "auth-host", "",
+		"Host (and port, if not the usual one) to send --auth-user to. Defaults to the --doc-base-url host. Never sent to any other host, even on a redirect.")
+	flag.BoolVar(&opts.netrc, "netrc", false,
+		"Use basic auth credentials from --netrc-file for the agenda and document hosts which have them.")
+	flag.StringVar(&opts.netrcFile, "netrc-file", "~/.netrc", "The netrc file for --netrc.")
+	flag.BoolVar(&opts.insecureSkipVerify, "insecure-skip-verify", false,
+		"Don't verify TLS certificates. Dangerous, only for mirrors with self-signed certificates.")
+	flag.IntVar(&opts.maxRedirects, "max-redirects", 10,
+		"Maximum number of redirects to follow for each request.")
+	flag.BoolVar(&opts.allowCrossHostRedirect, "allow-cross-host-redirect", false,
+		"Follow redirects to a different host than the one we asked.")
+	flag.Float64Var(&opts.rateLimit, "rate-limit", 0,
+		"Maximum document requests per second (0 for no limit).")
+	flag.StringVar(&opts.output, "output", "text",
+		"How to print the results: text or json.")
+	flag.StringVar(&opts.pruneOlderThan, "prune-older-than", "",
+		"After syncing, remove telechat directories older than this (e.g. 90d or 2160h).")
+	flag.IntVar(&opts.retries, "retries", 2,
+		"How many times to retry a failed download.")
+	flag.DurationVar(&opts.retryDelay, "retry-base-delay", time.Second,
+		"How long to wait before the first retry, doubled for each retry after that.")
 
 	flag.BoolVarP(&opts.verbose, "verbose", "v", false, "be more verbose.")
+	flag.BoolVarP(&opts.quiet, "quiet", "q", false, "only print failed downloads and the summary.")
 	flag.BoolVarP(&opts.debug, "debug", "d", false, "print debug information.")
+	flag.StringVar(&opts.logFile, "log-file", "", "also append logs to this file.")
+	flag.StringVar(&opts.logFormat, "log-format", "text", "log format: text or json.")
 
 	flag.Parse()
+	// loadEnv and loadConfig set flags too, so remember which ones were
+	// actually given on the command line.
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	if opts.version {
+		fmt.Printf("sync_telechat %v (commit %v, built %v)\n", version, commit, buildDate)
+		os.Exit(0)
+	}
+
+	if err := loadEnv(); err != nil {
+		log.Fatal(err)
+	}
+	if opts.config != "" {
+		if err := loadConfig(opts.config); err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	if opts.basedir == "" {
 		log.Fatal("You must specify a base directory")
 	}
 
+	// --agenda, --date and --from each choose what to sync, so one given on
+	// the command line overrides the others from the environment or config.
+	agenda := flag.CommandLine.Changed("agenda")
+	if given["agenda"] || given["date"] || given["from"] {
+		if !given["agenda"] {
+			opts.baseurl, agenda = telechat.JSONURL, false
+		}
+		if !given["date"] {
+			opts.date = ""
+		}
+		if !given["from"] {
+			opts.from = ""
+			if !given["to"] {
+				opts.to = ""
+			}
+		}
+	}
+
+	if opts.date != "" {
+		if _, err := time.Parse(telechat.DateLayout, opts.date); err != nil {
+			log.Fatalf("Bad --date %q, must be YYYY-MM-DD", opts.date)
+		}
+		if agenda {
+			log.Fatal("Only one of --date and --agenda may be given")
+		}
+		opts.baseurl = fmt.Sprintf(telechat.DatedJSONURL, opts.date)
+	}
+
+	if !telechat.ValidDateLayout(opts.dateLayout) {
+		log.Fatalf("Bad --date-layout %q, it must include the year, month and day, and be a relative path", opts.dateLayout)
+	}
+
+	if opts.flat && opts.dateLayout != telechat.DateLayout {
+		log.Fatal("--flat and --date-layout can't be used together")
+	}
+
+	if opts.to != "" && opts.from == "" {
+		log.Fatal("--to needs --from")
+	}
+	if opts.from != "" {
+		if opts.date != "" || agenda {
+			log.Fatal("--from can't be used with --date or --agenda")
+		}
+		if opts.to == "" {
+			opts.to = time.Now().Format(telechat.DateLayout)
+		}
+		for _, date := range []string{opts.from, opts.to} {
+			if _, err := time.Parse(telechat.DateLayout, date); err != nil {
+				log.Fatalf("Bad date %q, must be YYYY-MM-DD", date)
+			}
+		}
+		if opts.from > opts.to {
+			log.Fatalf("--from (%v) is after --to (%v)", opts.from, opts.to)
+		}
+	}
+
+	if opts.hookTimeout <= 0 {
+		log.Fatalf("--hook-timeout must be positive, not %v", opts.hookTimeout)
+	}
+	if opts.maxRuntime < 0 {
+		log.Fatalf("--max-runtime must not be negative, not %v", opts.maxRuntime)
+	}
+	if opts.timeout <= 0 {
+		log.Fatalf("--timeout must be positive, not %v", opts.timeout)
+	}
+	if opts.parallelism < 1 {
+		log.Fatalf("--parallelism must be at least 1, not %d", opts.parallelism)
+	}
+	if opts.dateParallelism < 1 {
+		log.Fatalf("--date-parallelism must be at least 1, not %d", opts.dateParallelism)
+	}
+
+	if opts.output != "text" && opts.output != "json" {
+		log.Fatalf("Unknown --output %q, must be text or json", opts.output)
+	}
+
+	if opts.nameFilter != "" {
+		var err error
+		if opts.nameFilterRE, err = regexp.Compile(opts.nameFilter); err != nil {
+			log.Fatalf("Bad --name-filter: %v", err)
+		}
+	}
+	if opts.nameExclude != "" {
+		var err error
+		if opts.nameExcludeRE, err = regexp.Compile(opts.nameExclude); err != nil {
+			log.Fatalf("Bad --name-exclude: %v", err)
+		}
+	}
+
+	if opts.pruneOlderThan != "" {
+		var err error
+		if opts.pruneAge, err = telechat.ParseAge(opts.pruneOlderThan); err != nil {
+			log.Fatalf("Bad --prune-older-than: %v", err)
+		}
+	}
+
+	if opts.compress != "" && opts.compress != "gzip" {
+		log.Fatalf("Unknown --compress %q, must be gzip", opts.compress)
+	}
+
+	// These need the documents on the local disk.
+	if opts.dest != "" {
+		switch {
+		case opts.hardlink:
+			log.Fatal("--hardlink only works without --dest")
+		case opts.noClobber:
+			log.Fatal("--no-clobber-across-dates only works without --dest")
+		case opts.postHook != "":
+			log.Fatal("--post-hook only works without --dest")
+		case opts.pruneOlderThan != "":
+			log.Fatal("--prune-older-than only works without --dest")
+		}
+	}
+	if opts.login.url != "" && opts.login.user == "" {
+		log.Fatal("--login-url needs --login-user")
+	}
+	if opts.smtp.host != "" && (opts.smtp.from == "" || len(opts.smtp.to) == 0) {
+		log.Fatal("--smtp-host needs --smtp-from and --smtp-to")
+	}
+	if opts.mergePDF != "" {
+		pdf := false
+		for _, format := range opts.formats {
+			pdf = pdf || format == "pdf"
+		}
+		if !pdf {
+			log.Fatal("--merge-pdf needs --format pdf")
+		}
+	}
+
+	if opts.feedItems < 1 {
+		log.Fatalf("--feed-items must be at least 1, not %d", opts.feedItems)
+	}
+
+	if opts.watch && opts.dryRun {
+		log.Fatal("Only one of --watch and --dry-run may be given")
+	}
+	if opts.watch && opts.pollInterval <= 0 {
+		log.Fatalf("--poll-interval must be positive, not %v", opts.pollInterval)
+	}
+
+	if opts.maxSize < 0 {
+		log.Fatalf("--max-size must not be negative, not %d", opts.maxSize)
+	}
+	if opts.maxRedirects < 0 {
+		log.Fatalf("--max-redirects must not be negative, not %d", opts.maxRedirects)
+	}
+	if opts.rateLimit < 0 {
+		log.Fatalf("--rate-limit must not be negative, not %v", opts.rateLimit)
+	}
+
+	if opts.retries < 0 {
+		log.Fatalf("--retries must not be negative, not %d", opts.retries)
+	}
+
+	for _, format := range opts.formats {
+		if !telechat.KnownFormat(format) {
+			log.Fatalf("Unknown format %q, must be one of: %v",
+				format, strings.Join(telechat.FormatNames(), ", "))
+		}
+	}
+	for format, template := range opts.urlTemplates {
+		if !telechat.KnownFormat(format) {
+			log.Fatalf("Unknown --url-template format %q, must be one of: %v",
+				format, strings.Join(telechat.FormatNames(), ", "))
+		}
+		if !strings.Contains(template, telechat.NamePlaceholder) {
+			log.Fatalf("--url-template for %v (%q) has no %v", format, template, telechat.NamePlaceholder)
+		}
+	}
+
 	if opts.debug {
 		log.SetLevel(log.DebugLevel)
 	} else if opts.verbose {
 		log.SetLevel(log.InfoLevel)
 	}
+
+	switch opts.logFormat {
+	case "text":
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		log.Fatalf("Unknown --log-format %q, must be text or json", opts.logFormat)
+	}
+
+	if opts.logFile != "" {
+		path, err := telechat.Expand(opts.logFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Fatalf("Error opening log file: %v", err)
+		}
+		log.SetOutput(io.MultiWriter(os.Stderr, file))
+	}
 }
 
 func init() {
@@ -219,25 +816,122 @@ func init() {
 func main() {
 	parseFlags()
 
+	// Cancelled on Ctrl-C (or kill), which abandons any downloads in flight.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// Convert the ~ (if any) into a home directory.
-	basedir, _ := expand(opts.basedir)
+	basedir, err := telechat.Expand(opts.basedir)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	var _, err = os.Stat(basedir)
+	if flag.NArg() > 0 {
+		if flag.Arg(0) != "diff" || flag.NArg() != 3 {
+			usage()
+		}
+		f := &telechat.Fetcher{BaseDir: basedir, DateLayout: opts.dateLayout, Flat: opts.flat}
+		if err := diffTelechats(os.Stdout, f, flag.Arg(1), flag.Arg(2)); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	_, err = os.Stat(basedir)
+	if os.IsNotExist(err) && opts.createBasedir && !opts.dryRun {
+		log.Infof("Creating %v", basedir)
+		err = os.MkdirAll(basedir, os.FileMode(opts.dirMode))
+	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "ERROR: The base directory (%v) does not exist.\n\n", basedir)
+		log.Errorf("The base directory (%v) does not exist (%v). Use --create-basedir to create it.", basedir, err)
 		usage() // Usage exits.
 	}
 
-	telechats, err := fetchAgenda(opts.baseurl)
+	client, err := newClient(opts)
 	if err != nil {
-		log.Fatalf("ERROR: %v\n\n", err)
+		log.Fatal(err)
 	}
-	log.Infof("Telechats: %v", telechats)
-	results := fetchDocs(basedir, telechats)
-	for _, result := range results {
-		if result != "" {
-			fmt.Printf("%v\n", result)
+	// Even with --dry-run, which still fetches the agenda.
+	if opts.login.url != "" {
+		if err := login(ctx, client, opts.login); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	store, err := newStorage(opts, basedir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	f := &telechat.Fetcher{
+		Client:          client,
+		BaseDir:         basedir,
+		Storage:         store,
+		Compress:        opts.compress == "gzip",
+		Formats:         opts.formats,
+		DirMode:         os.FileMode(opts.dirMode),
+		Timeout:         opts.timeout,
+		Parallelism:     opts.parallelism,
+		DateParallelism: opts.dateParallelism,
+		IncludeRFCs:     opts.includeRFCs,
+		Hardlink:        opts.hardlink,
+		NoClobber:       opts.noClobber,
+		DocBaseURL:      opts.docBaseURL,
+		URLTemplates:    opts.urlTemplates,
+		DateLayout:      opts.dateLayout,
+		Flat:            opts.flat,
+		Mirrors:         opts.mirrors,
+		MaxSize:         opts.maxSize,
+		CheckHead:       opts.checkHead,
+		PostHook:        opts.postHook,
+		HookTimeout:     opts.hookTimeout,
+		Overwrite:       opts.overwrite,
+		Verify:          opts.verify,
+		Retries:         opts.retries,
+		RetryDelay:      opts.retryDelay,
+	}
+	if opts.includeWriteup {
+		f.Extras = append(f.Extras, "writeup")
+	}
+	if opts.includeBallot {
+		f.Extras = append(f.Extras, "ballot")
+	}
+	if opts.progress && isTerminal(os.Stdout) {
+		f.Progress = os.Stderr
+	}
+	if opts.rateLimit > 0 {
+		f.Limiter = rate.NewLimiter(rate.Limit(opts.rateLimit), 1)
+	}
+	if !opts.watch {
+		totals, err := syncOnce(ctx, f)
+		recordMetrics(totals, err)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if ctx.Err() != nil {
+			log.Error("Interrupted, some downloads did not finish.")
+			os.Exit(1)
+		}
+		if totals.failed > 0 {
+			log.Errorf("%d download(s) failed.", totals.failed)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// Documents we already have are skipped, so each time around we only
+	// fetch the new ones.
+	for {
+		totals, err := syncOnce(ctx, f)
+		recordMetrics(totals, err)
+		if err != nil {
+			log.Error(err)
+		}
+		select {
+		case <-ctx.Done():
+			log.Info("Interrupted, stopping.")
+			os.Exit(0)
+		case <-time.After(opts.pollInterval):
 		}
 	}
-	os.Exit(0)
 }
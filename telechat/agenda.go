@@ -0,0 +1,327 @@
+package telechat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// What RFCs are called on the agenda.
+var rfcName = regexp.MustCompile(`^rfc[0-9]+$`)
+
+// Reads the raw agenda from source, which is a http(s) URL (fetched using
+// client), a local file, or "-" for stdin.
+func readAgenda(ctx context.Context, client *http.Client, source string) ([]byte, error) {
+	if source == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		path, err := Expand(source)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadFile(path)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating agenda request: %v", err)
+	}
+	// The agenda is big, and JSON compresses well.
+	acceptGzip(req)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	// A date with no agenda (yet) gets an error page, which we can't parse.
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("server returned %v", resp.Status)
+	}
+
+	body, _, err := responseBody(resp)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return ioutil.ReadAll(body)
+}
+
+// The parts of the agenda JSON we care about.
+type Agenda struct {
+	TelechatDate string             `json:"telechat-date"`
+	Sections     map[string]Section `json:"sections"`
+}
+
+// A section of the agenda, e.g. "2.1.1" (WG submissions, new items).
+type Section struct {
+	Title string `json:"title"`
+	Docs  []Doc  `json:"docs"`
+	WGs   []Doc  `json:"wgs"` // Charters, in the working group action sections.
+}
+
+// Values for Doc.Kind.
+const (
+	KindDocument   = ""
+	KindCharter    = "charter"
+	KindRFC        = "rfc"
+	KindManagement = "management"
+)
+
+// The agenda section whose subsections are management items.
+const kManagementSection = "6"
+
+// An item on the agenda, usually a document.
+type Doc struct {
+	Docname string `json:"docname"`
+	Rev     string `json:"rev,omitempty"`
+	AD      string `json:"ad"` // The responsible AD's name.
+	// E.g. "Proposed Standard" or "Informational".
+	IntendedStatus string `json:"intended-std-level"`
+
+	// For charters, the working group.
+	WGName  string `json:"wgname"`
+	Acronym string `json:"acronym"`
+
+	// Context from the agenda, e.g. "Returning item", or the ballot's state.
+	Note   string `json:"note"`
+	Ballot string `json:"ballot-status"`
+
+	// The rest are filled in by ParseAgenda.
+	Kind string `json:"-"`
+	// The section of the agenda the item is in. For management items, which
+	// have no Docname, this is the item itself.
+	Section      string `json:"-"`
+	SectionTitle string `json:"-"`
+	// Any other sections the document is listed in, see Agenda.Docs.
+	AlsoIn []string `json:"-"`
+}
+
+// Returns the agenda's note and ballot status for the document, if any, as
+// one string.
+func (d Doc) Notes() string {
+	var notes []string
+	for _, note := range []string{d.Ballot, d.Note} {
+		if note = strings.TrimSpace(note); note != "" {
+			notes = append(notes, note)
+		}
+	}
+	return strings.Join(notes, "; ")
+}
+
+// Returns the name we use for the document: docname-rev, or just the docname
+// for RFCs (and anything else the agenda gives no revision for). Management
+// items have no name.
+func (d Doc) Name() string {
+	switch d.Kind {
+	case KindManagement:
+		return ""
+	case KindRFC:
+		return d.Docname
+	}
+	if d.Rev == "" {
+		return d.Docname
+	}
+	return d.Docname + "-" + d.Rev
+}
+
+// Reports whether there is anything to download for the item.
+func (d Doc) downloadable() bool {
+	return d.Kind != KindManagement
+}
+
+// Reports whether agenda section number a ("2.1.1") comes before b ("2.1.10").
+func sectionLess(a, b string) bool {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] == bs[i] {
+			continue
+		}
+		an, aerr := strconv.Atoi(as[i])
+		bn, berr := strconv.Atoi(bs[i])
+		if aerr != nil || berr != nil {
+			return as[i] < bs[i]
+		}
+		return an < bn
+	}
+	return len(as) < len(bs)
+}
+
+// Parses the agenda JSON from r. The date is checked, and the documents are
+// filled in with their Kind and the section they are in (see Doc), so the
+// result is ready to use, e.g. with Agenda.Docs.
+func ParseAgenda(r io.Reader) (*Agenda, error) {
+	var agenda Agenda
+	if err := json.NewDecoder(r).Decode(&agenda); err != nil {
+		return nil, fmt.Errorf("error unmarshalling agenda: %v", err)
+	}
+
+	// Early agendas may not have a date yet. It becomes a directory name, so
+	// it has to look like one.
+	agenda.TelechatDate = strings.TrimSpace(agenda.TelechatDate)
+	if agenda.TelechatDate == "" {
+		return nil, fmt.Errorf("agenda has no \"telechat-date\" (perhaps it isn't finished yet)")
+	}
+	if _, err := time.Parse(DateLayout, agenda.TelechatDate); err != nil {
+		return nil, fmt.Errorf("agenda has a bad \"telechat-date\" %q, should be YYYY-MM-DD", agenda.TelechatDate)
+	}
+	for _, section := range agenda.SectionNumbers() {
+		content := agenda.Sections[section]
+		for i := range content.Docs {
+			doc := &content.Docs[i]
+			if doc.Docname == "" {
+				return nil, fmt.Errorf("doc %d in section %q has no \"docname\"", i, section)
+			}
+			doc.Rev = strings.TrimSpace(doc.Rev)
+			if doc.Rev == "" {
+				log.Debugf("No revision for %v, using just the name", doc.Docname)
+			}
+			if rfcName.MatchString(doc.Docname) {
+				doc.Kind = KindRFC
+			}
+			doc.Section, doc.SectionTitle = section, content.Title
+		}
+		for i := range content.WGs {
+			doc := &content.WGs[i]
+			if doc.Docname == "" {
+				return nil, fmt.Errorf("charter %d in section %q has no \"docname\"", i, section)
+			}
+			doc.Kind = KindCharter
+			doc.Section, doc.SectionTitle = section, content.Title
+		}
+	}
+	return &agenda, nil
+}
+
+// Returns the numbers of the agenda's sections, in agenda order.
+func (a *Agenda) SectionNumbers() []string {
+	var sections []string
+	for section := range a.Sections {
+		sections = append(sections, section)
+	}
+	sort.Slice(sections, func(i, j int) bool { return sectionLess(sections[i], sections[j]) })
+	return sections
+}
+
+// Returns the items on the agenda, in agenda order: the documents and the
+// charters (from the WG action sections), and with includeManagement the
+// management items too, see Doc.Kind.
+// Within each section they are sorted by docname (see docLess), so
+// everything made from them comes out the same every time.
+// Documents are sometimes listed in more than one section. They are only
+// returned once, where they are first listed, with the other sections in
+// Doc.AlsoIn.
+func (a *Agenda) Docs(includeManagement bool) []Doc {
+	// Even if there turn out to be no documents (yet).
+	docs := []Doc{}
+	// Where each document is in docs, by Doc.Name.
+	seen := make(map[string]int)
+	add := func(doc Doc) {
+		if i, ok := seen[doc.Name()]; ok {
+			log.Infof("%v is listed in both %v and %v, only fetching it once", doc.Name(), docs[i].Section, doc.Section)
+			docs[i].AlsoIn = append(docs[i].AlsoIn, doc.Section)
+			return
+		}
+		seen[doc.Name()] = len(docs)
+		docs = append(docs, doc)
+	}
+	for _, section := range a.SectionNumbers() {
+		content := a.Sections[section]
+		for _, doc := range content.Docs {
+			log.Debugf("Doc: %s (date: %s)", doc.Docname, a.TelechatDate)
+			add(doc)
+		}
+		for _, doc := range content.WGs {
+			log.Debugf("Charter: %s (date: %s)", doc.Docname, a.TelechatDate)
+			add(doc)
+		}
+		if includeManagement && strings.HasPrefix(section, kManagementSection+".") {
+			item := Doc{Kind: KindManagement, Section: section, SectionTitle: content.Title}
+			log.Debugf("Management item: %s %s (date: %s)", section, content.Title, a.TelechatDate)
+			docs = append(docs, item)
+		}
+	}
+	sort.SliceStable(docs, func(i, j int) bool { return docLess(docs[i], docs[j]) })
+	return docs
+}
+
+// Reports whether a comes before b: by agenda section, and then by docname.
+func docLess(a, b Doc) bool {
+	if a.Section != b.Section {
+		return sectionLess(a.Section, b.Section)
+	}
+	return a.Docname < b.Docname
+}
+
+// Fetches the agenda from source (see readAgenda) and returns the documents
+// on it (see Agenda.Docs), keyed by telechat date.
+// Also returns the raw agenda, for saving.
+func FetchAgenda(ctx context.Context, client *http.Client, source string, includeManagement bool) (map[string][]Doc, []byte, error) {
+	result := make(map[string][]Doc)
+
+	body, err := readAgenda(ctx, client, source)
+	if err != nil {
+		return result, nil, fmt.Errorf("error reading agenda: %v", err)
+	}
+
+	agenda, err := ParseAgenda(bytes.NewReader(body))
+	if err != nil {
+		log.Debugf("Bad agenda: %v", string(body))
+		return result, nil, err
+	}
+	result[agenda.TelechatDate] = agenda.Docs(includeManagement)
+	return result, body, nil
+}
+
+// Returns how many downloadable documents each telechat has.
+func CountDownloadable(telechats map[string][]Doc) map[string]int {
+	counts := make(map[string]int)
+	for date, docs := range telechats {
+		counts[date] = 0
+		for _, doc := range docs {
+			if doc.downloadable() {
+				counts[date]++
+			}
+		}
+	}
+	return counts
+}
+
+// Fetches each of sources with FetchAgenda, and merges the documents. Also
+// returns the raw agendas, by date.
+// With several sources, ones we can't fetch are logged and left out, unless
+// that is all of them.
+func FetchAgendas(ctx context.Context, client *http.Client, sources []string, includeManagement bool) (map[string][]Doc, map[string][]byte, error) {
+	telechats := make(map[string][]Doc)
+	raws := make(map[string][]byte)
+	var lastErr error
+	for _, source := range sources {
+		docs, raw, err := FetchAgenda(ctx, client, source, includeManagement)
+		if err != nil {
+			if len(sources) > 1 {
+				log.Errorf("Error fetching %v: %v", source, err)
+			}
+			lastErr = err
+			continue
+		}
+		for date := range docs {
+			telechats[date], raws[date] = docs[date], raw
+		}
+	}
+	if len(telechats) == 0 && lastErr != nil {
+		return nil, nil, lastErr
+	}
+	return telechats, raws, nil
+}
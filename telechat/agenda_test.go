@@ -0,0 +1,96 @@
+package telechat
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// The parts of a Doc ParseAgenda and Agenda.Docs fill in.
+type parsedDoc struct {
+	Name, Rev, Kind, Section string
+}
+
+func TestParseAgenda(t *testing.T) {
+	tests := []struct {
+		file       string
+		management bool
+		date       string
+		want       []parsedDoc
+		err        string
+	}{
+		{
+			file: "normal.json",
+			date: "2024-06-13",
+			want: []parsedDoc{
+				{"draft-ietf-dnsop-foo-03", "03", KindDocument, "2.1.1"},
+				{"draft-ietf-opsawg-baz-11", "11", KindDocument, "2.1.1"},
+				{"draft-bar-01", "01", KindDocument, "3.1.1"},
+				{"charter-ietf-newwg-00-01", "00-01", KindCharter, "4.1.1"},
+			},
+		},
+		{
+			file:       "normal.json",
+			management: true,
+			date:       "2024-06-13",
+			want: []parsedDoc{
+				{"draft-ietf-dnsop-foo-03", "03", KindDocument, "2.1.1"},
+				{"draft-ietf-opsawg-baz-11", "11", KindDocument, "2.1.1"},
+				{"draft-bar-01", "01", KindDocument, "3.1.1"},
+				{"charter-ietf-newwg-00-01", "00-01", KindCharter, "4.1.1"},
+				{"", "", KindManagement, "6.1"},
+			},
+		},
+		{
+			file:       "empty.json",
+			management: true,
+			date:       "2024-06-27",
+			want:       []parsedDoc{},
+		},
+		{
+			file: "rfc.json",
+			date: "2024-07-11",
+			want: []parsedDoc{
+				{"draft-ietf-dnsop-foo-04", "04", KindDocument, "3.3.1"},
+				{"rfc6761", "", KindRFC, "3.3.1"},
+			},
+		},
+		{
+			file: "missing-docname.json",
+			err:  `doc 0 in section "2.1.1" has no "docname"`,
+		},
+		{
+			file: "missing-date.json",
+			err:  `agenda has no "telechat-date" (perhaps it isn't finished yet)`,
+		},
+	}
+	for _, test := range tests {
+		input, err := os.Open(filepath.Join("testdata", test.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		agenda, err := ParseAgenda(input)
+		input.Close()
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%v: ParseAgenda returned error %v, want %q", test.file, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: ParseAgenda returned error %v", test.file, err)
+			continue
+		}
+		if agenda.TelechatDate != test.date {
+			t.Errorf("%v: date is %q, want %q", test.file, agenda.TelechatDate, test.date)
+		}
+		got := []parsedDoc{}
+		for _, doc := range agenda.Docs(test.management) {
+			got = append(got, parsedDoc{doc.Name(), doc.Rev, doc.Kind, doc.Section})
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v (management %v): Docs returned\n%+v\nwant\n%+v", test.file, test.management, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package telechat
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The name of the checksum file in each date directory, in the format
+// sha256sum(1) reads, so `sha256sum -c SHA256SUMS` works.
+const SumsName = "SHA256SUMS"
+
+// Returns the hex SHA-256 of the file at path.
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	return hashReader(file)
+}
+
+// Returns the hex SHA-256 of the document name in store.
+func hashStored(ctx context.Context, store Storage, name string) (string, error) {
+	reader, err := store.Open(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+	return hashReader(reader)
+}
+
+// Returns the hex SHA-256 of everything in reader.
+func hashReader(reader io.Reader) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, reader); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+// Reads the checksums in dir, as written by writeSums, by file name.
+func readSums(dir string, prefix string) (map[string]string, error) {
+	file, err := os.Open(filepath.Join(dir, prefix+SumsName))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	sums := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 {
+			sums[strings.TrimPrefix(fields[1], "*")] = fields[0]
+		}
+	}
+	return sums, scanner.Err()
+}
+
+// Writes the checksums of the files results say we have for the telechat on
+// date into dir. Our files there all start with prefix (see Fetcher.Prefix).
+func writeSums(dir string, prefix string, date string, results []Result) error {
+	var lines []string
+	for _, result := range results {
+		if result.Date == date && result.Err == nil && !result.TooLarge && result.SHA256 != "" {
+			lines = append(lines, fmt.Sprintf("%v  %v\n", result.SHA256, result.File))
+		}
+	}
+	sort.Strings(lines)
+	return ioutil.WriteFile(filepath.Join(dir, prefix+SumsName), []byte(strings.Join(lines, "")), 0644)
+}
@@ -0,0 +1,86 @@
+package telechat
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Returns the directory (relative to the base directory) for the telechat
+// on date (as the agenda writes it), named using layout, a Go time layout
+// which may have "/"s in it for nested directories.
+func dateDir(date string, layout string) string {
+	if layout == DateLayout {
+		return date
+	}
+	t, err := time.Parse(DateLayout, date)
+	if err != nil {
+		return date // ParseAgenda checks the date, so this shouldn't happen.
+	}
+	return filepath.FromSlash(t.Format(layout))
+}
+
+// A telechat directory (or with --flat, file) we found on disk.
+type datedPath struct {
+	path string
+	date time.Time
+}
+
+// Returns the telechat directories in basedir named using layout (see
+// dateDir). Anything not named like a date is left out.
+func dateDirs(basedir string, layout string) ([]datedPath, error) {
+	depth := strings.Count(layout, "/") + 1
+	pattern := filepath.Join(append([]string{basedir}, strings.Split(strings.Repeat("*", depth), "")...)...)
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	var dirs []datedPath
+	for _, match := range matches {
+		rel, err := filepath.Rel(basedir, match)
+		if err != nil {
+			continue
+		}
+		date, err := time.Parse(layout, filepath.ToSlash(rel))
+		if err != nil {
+			continue
+		}
+		if info, err := os.Stat(match); err != nil || !info.IsDir() {
+			continue
+		}
+		dirs = append(dirs, datedPath{match, date})
+	}
+	return dirs, nil
+}
+
+// Returns the files in basedir whose names start with a date (as the agenda
+// writes it) and "-", as they do with --flat.
+func flatFiles(basedir string) ([]datedPath, error) {
+	entries, err := ioutil.ReadDir(basedir)
+	if err != nil {
+		return nil, err
+	}
+	var files []datedPath
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || len(name) <= len(DateLayout) || name[len(DateLayout)] != '-' {
+			continue
+		}
+		date, err := time.Parse(DateLayout, name[:len(DateLayout)])
+		if err != nil {
+			continue
+		}
+		files = append(files, datedPath{filepath.Join(basedir, name), date})
+	}
+	return files, nil
+}
+
+// Reports whether layout can name telechat directories: every date has to
+// get a different name, which we can turn back into the date.
+func ValidDateLayout(layout string) bool {
+	date := time.Date(2006, time.November, 23, 0, 0, 0, 0, time.UTC)
+	parsed, err := time.Parse(layout, date.Format(layout))
+	return err == nil && parsed.Equal(date) && !strings.HasPrefix(layout, "/") && !strings.Contains(layout, "..")
+}
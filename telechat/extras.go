@@ -0,0 +1,234 @@
+package telechat
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// The latest shepherd writeup for a document, from the datatracker API,
+// given the docname.
+const kWriteupURL = "https://datatracker.ietf.org/api/v1/doc/writeupdocevent/?format=json&type=changed_protocol_writeup&order_by=-time&limit=1&doc__name=%s"
+
+// The ballot positions on a document, from the datatracker API, given the
+// docname. These are all the positions taken, oldest first, so the latest for
+// each AD is the current one.
+const kBallotURL = "https://datatracker.ietf.org/api/v1/doc/ballotpositiondocevent/?format=json&order_by=time&limit=0&doc__name=%s"
+
+// Nobody's writeup (or ballot) is this big.
+const kMaxExtraSize = 10 << 20
+
+// Something about a document we can get from the datatracker besides the
+// document itself, see Fetcher.Extras.
+type extra struct {
+	// Where it lives, given the docname.
+	url string
+	// Added to the document's name (see Doc.Name) for our copy.
+	suffix string
+	// Turns the response into what we keep, or returns errNoExtra if there's
+	// nothing there.
+	extract func(body []byte) ([]byte, error)
+	// Fetch it again every time, as it changes, rather than keeping the copy
+	// we have.
+	refresh bool
+}
+
+// The extras we know how to fetch, keyed by the name used in Fetcher.Extras
+// (and Result.Format).
+var extras = map[string]extra{
+	"writeup": {kWriteupURL, ".writeup.txt", writeupText, false},
+	"ballot":  {kBallotURL, ".ballot.json", ballotPositions, true},
+}
+
+// Returns what the names of our copies in format (a format or an extra) end
+// with, before any GzipSuffix.
+func extension(format string) string {
+	if e, ok := extras[format]; ok {
+		return e.suffix
+	}
+	return formats[format].extension
+}
+
+// Returned by an extra's extract when the document doesn't have one (yet).
+var errNoExtra = errors.New("none")
+
+// Pulls the writeup's text out of the datatracker's API response.
+func writeupText(body []byte) ([]byte, error) {
+	var page struct {
+		Objects []struct {
+			Text string `json:"text"`
+		} `json:"objects"`
+	}
+	if err := json.Unmarshal(body, &page); err != nil {
+		return nil, fmt.Errorf("error unmarshalling writeup: %v", err)
+	}
+	if len(page.Objects) == 0 || strings.TrimSpace(page.Objects[0].Text) == "" {
+		return nil, errNoExtra
+	}
+	text := strings.ReplaceAll(page.Objects[0].Text, "\r\n", "\n")
+	return []byte(strings.TrimRight(text, "\n") + "\n"), nil
+}
+
+// Checks the datatracker's API response has some ballot positions in it, and
+// returns it as it is.
+func ballotPositions(body []byte) ([]byte, error) {
+	var page struct {
+		Objects []json.RawMessage `json:"objects"`
+	}
+	if err := json.Unmarshal(body, &page); err != nil {
+		return nil, fmt.Errorf("error unmarshalling ballot: %v", err)
+	}
+	if len(page.Objects) == 0 {
+		return nil, errNoExtra
+	}
+	return body, nil
+}
+
+// Returns the extras to fetch for doc: only drafts have them.
+func (f *Fetcher) docExtras(doc Doc) []string {
+	if doc.Kind != KindDocument {
+		return nil
+	}
+	return f.Extras
+}
+
+// Returns where to get the name extra for doc from, and where to put it.
+func (f *Fetcher) extraTarget(date string, doc Doc, name string) (string, string) {
+	e := extras[name]
+	filename := doc.Name() + e.suffix
+	return fmt.Sprintf(e.url, url.QueryEscape(doc.Docname)), filepath.Join(f.Dir(date), f.Prefix(date)+filename+f.suffix())
+}
+
+// Fetches the name extra (see extras) for doc, like fetchDoc does the
+// document. Ones we already have are skipped, unless f.Overwrite is set (or
+// the extra is refreshed every time), and documents which don't have one are
+// Missing rather than failures.
+func (f *Fetcher) fetchExtra(ctx context.Context, date string, doc Doc, name string, done chan Result) {
+	url, fullname := f.extraTarget(date, doc, name)
+	result := Result{Date: date, Doc: doc.Name(), Format: name, URL: url, File: filepath.Base(fullname)}
+
+	info, exists, err := f.Storage.Exists(ctx, fullname)
+	if err != nil {
+		log.Warnf("Can't tell if we have %v: %v", f.Storage.Location(fullname), err)
+	}
+	if exists && !f.Overwrite && !extras[name].refresh {
+		result.Skipped, result.Bytes = true, info.Size()
+		if sum, err := hashStored(ctx, f.Storage, fullname); err == nil {
+			result.SHA256 = sum
+		}
+		done <- result
+		return
+	}
+
+	delay := f.RetryDelay
+	for attempt := 1; ; attempt++ {
+		result.Attempts = attempt
+		var data []byte
+		data, result.Err = f.getExtra(ctx, url, extras[name])
+		if result.Err == errNoExtra {
+			log.Debugf("No %v for %v", name, doc.Name())
+			result.Err, result.Missing = nil, true
+		} else if result.Err == nil {
+			result.Err = f.store(ctx, fullname, data, &result)
+		}
+		if result.Err == nil || attempt > f.Retries || ctx.Err() != nil {
+			done <- result
+			return
+		}
+		log.Infof("Attempt %d for %v failed, retrying in %v: %v", attempt, url, delay, result.Err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+		}
+		delay *= 2
+	}
+}
+
+// Gets e from url, with the same limits as download.
+func (f *Fetcher) getExtra(ctx context.Context, url string, e extra) ([]byte, error) {
+	if f.Limiter != nil {
+		if err := f.Limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+	timed, cancel := context.WithTimeout(ctx, f.Timeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(timed, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+	acceptGzip(request)
+	response, err := f.Client.Do(request)
+	if err == nil {
+		defer response.Body.Close()
+	}
+	switch {
+	case err != nil && ctx.Err() != nil:
+		return nil, fmt.Errorf("abandoned downloading %v: %v", url, ctx.Err())
+	case err != nil && timed.Err() == context.DeadlineExceeded:
+		return nil, fmt.Errorf("timeout (%v) downloading %v", f.Timeout, url)
+	case err != nil:
+		return nil, err
+	case response.StatusCode == http.StatusNotFound:
+		return nil, errNoExtra
+	case response.StatusCode < 200 || response.StatusCode > 299:
+		return nil, fmt.Errorf("server returned %v", response.Status)
+	}
+	body, _, err := responseBody(response)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(body, kMaxExtraSize))
+	if err != nil {
+		return nil, err
+	}
+	return e.extract(data)
+}
+
+// Stores data as fullname (gzipped, with f.Compress), setting result.Bytes
+// (and result.Received) and result.SHA256.
+func (f *Fetcher) store(ctx context.Context, fullname string, data []byte, result *Result) error {
+	output, err := f.Storage.Create(ctx, fullname, false)
+	if err != nil {
+		return fmt.Errorf("error creating the partial %v: %v", f.Storage.Location(fullname), err)
+	}
+	hash := sha256.New()
+	var writer io.Writer = io.MultiWriter(output, hash)
+	var zipper *gzip.Writer
+	if f.Compress {
+		zipper = gzip.NewWriter(writer)
+		writer = zipper
+	}
+	_, err = writer.Write(data)
+	if zipper != nil {
+		if zerr := zipper.Close(); err == nil {
+			err = zerr
+		}
+	}
+	if cerr := output.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		f.Storage.Discard(ctx, fullname)
+		return fmt.Errorf("error writing the partial %v: %v", f.Storage.Location(fullname), err)
+	}
+	if err := f.Storage.Finalize(ctx, fullname, time.Time{}); err != nil {
+		return err
+	}
+	result.Bytes, result.SHA256 = int64(len(data)), fmt.Sprintf("%x", hash.Sum(nil))
+	result.Received = result.Bytes
+	return nil
+}
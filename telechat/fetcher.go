@@ -0,0 +1,906 @@
+package telechat
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+// Downloads documents into BaseDir, using Client for all the requests.
+// Client, BaseDir, Formats, Storage, DirMode, DateLayout, Timeout and the
+// parallelisms must be set (NewFetcher does), the rest can be left alone.
+type Fetcher struct {
+	Client  *http.Client
+	BaseDir string
+	Formats []string
+	// Where the documents end up, see Storage.
+	Storage Storage
+	// Gzip the documents, adding GzipSuffix to their names.
+	Compress bool
+
+	// Permissions for the directories we create (the documents' are up to
+	// Storage).
+	DirMode os.FileMode
+
+	// For each document request, see download.
+	Timeout time.Duration
+	// See FetchDocs and fetchDate.
+	Parallelism     int
+	DateParallelism int
+	// If set, a line is written here as each download finishes.
+	Progress io.Writer
+
+	// If set, every document request waits for this first.
+	Limiter *rate.Limiter
+	// Download RFCs too, not just drafts.
+	IncludeRFCs bool
+	// Hardlink (or with NoClobber copy) documents we already have under
+	// another date, rather than downloading them again.
+	Hardlink  bool
+	NoClobber bool
+	// If set, where to get documents from instead of the IETF servers.
+	DocBaseURL string
+	// How the date directories are named, see dateDir.
+	DateLayout string
+	// Put everything straight into BaseDir, with the date at the start of
+	// the file names, rather than in date directories.
+	Flat bool
+	// URLs to get documents from, by format, see target.
+	URLTemplates map[string]string
+	// Where else to look (in order) if a download fails, see downloadAny.
+	Mirrors []string
+
+	// If set, documents bigger than this many bytes aren't downloaded.
+	MaxSize int64
+
+	// Also fetch these for each draft, e.g. "writeup" or "ballot", see extras.
+	Extras []string
+
+	// Run on each document we download, see runHook.
+	PostHook    string
+	HookTimeout time.Duration
+
+	// See fetchDoc.
+	CheckHead  bool
+	Overwrite  bool
+	Verify     bool
+	Retries    int
+	RetryDelay time.Duration
+}
+
+// Returns a Fetcher which downloads PDFs into basedir (on the local disk)
+// using client, with the same defaults as sync_telechat. DocBaseURL and so on
+// can be set before using it, e.g. to point it at a test server.
+func NewFetcher(client *http.Client, basedir string) *Fetcher {
+	return &Fetcher{
+		Client:          client,
+		BaseDir:         basedir,
+		Formats:         []string{"pdf"},
+		Storage:         LocalStorage{FileMode: 0644},
+		DirMode:         0755,
+		DateLayout:      DateLayout,
+		Timeout:         time.Minute,
+		Parallelism:     8,
+		DateParallelism: 2,
+		HookTimeout:     time.Minute,
+	}
+}
+
+// Downloads url into fullname, replacing anything already there.
+// Sets result.Bytes to the size of the document (and result.Received to how
+// much of it we got this time, see below), result.SHA256 to the
+// checksum of the document, and result.ETag and result.LastModified from the
+// response.
+// Downloads shorter (or longer) than the Content-Length, or which don't look
+// like the format we asked for (see checkContent), are failures.
+// With f.MaxSize, anything bigger than that is abandoned (as soon as we know)
+// and we return errTooLarge, with result.Bytes set to the size if the server
+// said.
+//
+// If result.ETag or result.LastModified are already set (from an earlier
+// download) the request is conditional, and if the server says the document
+// hasn't changed since, we return errNotModified and leave fullname alone.
+//
+// The body is written to a partial copy of fullname (see Storage), which is
+// only finalized once it has been completely received, so fullname existing
+// means we have the whole document. If the transfer fails part way (including
+// ctx being cancelled) the partial copy is kept, and the next attempt asks the
+// server for just the rest of it with a Range request. Servers which ignore
+// that send the whole document again, which replaces the partial copy.
+//
+// Each request gets f.Timeout (not counting any wait for f.Limiter), after
+// which it is cancelled and reported as a timeout.
+func (f *Fetcher) download(ctx context.Context, url string, fullname string, result *Result) error {
+	result.Bytes, result.Received = 0, 0
+	if f.Limiter != nil {
+		if err := f.Limiter.Wait(ctx); err != nil {
+			return err
+		}
+	}
+	timed, cancel := context.WithTimeout(ctx, f.Timeout)
+	defer cancel()
+	err := f.transfer(timed, url, fullname, result)
+	// Rather than whatever error the cancellation happened to cause.
+	switch {
+	case err == nil:
+	case ctx.Err() != nil:
+		return fmt.Errorf("abandoned downloading %v: %v", url, ctx.Err())
+	case timed.Err() == context.DeadlineExceeded:
+		return fmt.Errorf("timeout (%v) downloading %v", f.Timeout, url)
+	}
+	return err
+}
+
+// Does the work of download, with no timeout of its own.
+func (f *Fetcher) transfer(ctx context.Context, url string, fullname string, result *Result) error {
+	partial, offset, err := f.Storage.Partial(ctx, fullname)
+	if err != nil {
+		return fmt.Errorf("error reading the partial %v: %v", f.Storage.Location(fullname), err)
+	}
+	// We'd need to know how much of the document the compressed partial copy
+	// has, so start again.
+	if partial != nil && f.Compress {
+		partial.Close()
+		f.Storage.Discard(ctx, fullname)
+		partial, offset = nil, 0
+	}
+	if partial != nil {
+		defer partial.Close()
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("error creating request: %v", err)
+	}
+	if result.ETag != "" {
+		request.Header.Set("If-None-Match", result.ETag)
+	}
+	if result.LastModified != "" {
+		request.Header.Set("If-Modified-Since", result.LastModified)
+	}
+	if offset > 0 {
+		request.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	} else {
+		acceptGzip(request)
+	}
+	response, err := f.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotModified {
+		return errNotModified
+	}
+	// Usually because the document has shrunk since we started, so our
+	// partial copy is no good.
+	if response.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0 {
+		log.Infof("Can't resume %v, starting again", url)
+		f.Storage.Discard(ctx, fullname)
+		return f.download(ctx, url, fullname, result)
+	}
+	// Otherwise we would save the server's error page as the document.
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("server returned %v", response.Status)
+	}
+	resuming := offset > 0 && response.StatusCode == http.StatusPartialContent
+	if resuming {
+		var start int64
+		contentRange := response.Header.Get("Content-Range")
+		if _, err := fmt.Sscanf(contentRange, "bytes %d-", &start); err != nil || start != offset {
+			f.Storage.Discard(ctx, fullname)
+			return fmt.Errorf("can't resume at %d, server sent %q", offset, contentRange)
+		}
+		log.Infof("Resuming %v at %d bytes", url, offset)
+	}
+	decoded, compressed, err := responseBody(response)
+	if err != nil {
+		return err
+	}
+	defer decoded.Close()
+	// We didn't ask for it, and can't append it to what we have.
+	if resuming && compressed {
+		f.Storage.Discard(ctx, fullname)
+		return fmt.Errorf("can't resume at %d, server sent it compressed", offset)
+	}
+
+	// Don't even start on documents we know are too big.
+	if f.MaxSize > 0 && response.ContentLength >= 0 && !compressed {
+		size := response.ContentLength
+		if resuming {
+			size += offset
+		}
+		if size > f.MaxSize {
+			f.Storage.Discard(ctx, fullname)
+			result.Bytes = size
+			return errTooLarge
+		}
+	}
+
+	// We keep the extension we asked for (so the next run finds the file),
+	// but a different type is worth knowing about.
+	if header := response.Header.Get("Content-Type"); header != "" {
+		if mediaType, _, err := mime.ParseMediaType(header); err == nil &&
+			mediaType != "application/octet-stream" && !matchAny(mediaTypes[result.Format], mediaType) {
+			log.Warnf("%v: asked for %v, but the server sent %v", url, result.Format, mediaType)
+		}
+	}
+
+	// Look before we write, so we don't save (say) an HTML error page as a PDF.
+	// (When resuming, we looked at the start the first time.)
+	body := bufio.NewReaderSize(decoded, kSniffLength)
+	if !resuming {
+		head, _ := body.Peek(kSniffLength)
+		if err := checkContent(result.Format, head); err != nil {
+			return err
+		}
+	}
+
+	hash := sha256.New()
+	if resuming {
+		// The checksum is of the whole document, so start with what we have.
+		if _, err := io.Copy(hash, partial); err != nil {
+			return fmt.Errorf("error reading the partial %v: %v", f.Storage.Location(fullname), err)
+		}
+	}
+	output, err := f.Storage.Create(ctx, fullname, resuming)
+	if err != nil {
+		return fmt.Errorf("error creating the partial %v: %v", f.Storage.Location(fullname), err)
+	}
+
+	// Without a Content-Length we find out the hard way, but stop as soon as
+	// we do.
+	var source io.Reader = body
+	if f.MaxSize > 0 {
+		source = io.LimitReader(body, f.MaxSize-offset+1)
+	}
+	// The checksum is of what we store, so sha256sum -c works.
+	var writer io.Writer = io.MultiWriter(output, hash)
+	var zipper *gzip.Writer
+	if f.Compress {
+		zipper = gzip.NewWriter(writer)
+		writer = zipper
+	}
+	n, err := io.Copy(writer, source)
+	if zipper != nil {
+		if zerr := zipper.Close(); err == nil {
+			err = zerr
+		}
+	}
+	result.Bytes, result.Received = n, n
+	if resuming {
+		result.Bytes += offset
+	}
+	if err == nil && f.MaxSize > 0 && offset+n > f.MaxSize {
+		output.Close()
+		f.Storage.Discard(ctx, fullname)
+		result.Bytes, result.Received = 0, 0
+		return errTooLarge
+	}
+	// A dropped connection can look like a clean EOF, so check we got
+	// everything the server said it was sending (if it said). Gzip checks
+	// that for itself.
+	if err == nil && response.ContentLength >= 0 && !compressed && n != response.ContentLength {
+		err = fmt.Errorf("truncated download, got %d of %d bytes", n, response.ContentLength)
+	}
+	// Keep what we got to resume from, unless we couldn't write it.
+	if cerr := output.Close(); cerr != nil {
+		f.Storage.Discard(ctx, fullname)
+		return fmt.Errorf("error writing the partial %v: %v", f.Storage.Location(fullname), cerr)
+	}
+	if err != nil {
+		return err
+	}
+	modified, _ := http.ParseTime(response.Header.Get("Last-Modified"))
+	if err := f.Storage.Finalize(ctx, fullname, modified); err != nil {
+		return err
+	}
+	result.ETag = response.Header.Get("ETag")
+	result.LastModified = response.Header.Get("Last-Modified")
+	result.SHA256 = fmt.Sprintf("%x", hash.Sum(nil))
+	return nil
+}
+
+// Downloads url into fullname (see download), and if that fails tries
+// filename (what the servers call it, see target) from each of f.Mirrors in
+// turn. Sets result.URL to wherever it came from in the end, and
+// result.Mirror to the mirror, if it was one.
+func (f *Fetcher) downloadAny(ctx context.Context, url string, filename string, fullname string, result *Result) error {
+	result.URL, result.Mirror = url, ""
+	err := f.download(ctx, url, fullname, result)
+	if err == nil || err == errNotModified || err == errTooLarge || len(f.Mirrors) == 0 {
+		return err
+	}
+	failures := []string{fmt.Sprintf("%v: %v", url, err)}
+	for _, mirror := range f.Mirrors {
+		if ctx.Err() != nil {
+			break
+		}
+		mirrorURL := strings.TrimSuffix(mirror, "/") + "/" + filename
+		log.Infof("%v failed, trying %v: %v", url, mirrorURL, err)
+		err = f.download(ctx, mirrorURL, fullname, result)
+		if err == nil || err == errNotModified || err == errTooLarge {
+			result.URL, result.Mirror = mirrorURL, mirror
+			return err
+		}
+		failures = append(failures, fmt.Sprintf("%v: %v", mirrorURL, err))
+	}
+	return errors.New(strings.Join(failures, "; "))
+}
+
+// Asks the server (with a HEAD request) whether url has changed from our copy
+// of it, described by info: if its Content-Length isn't our size, or its
+// Last-Modified is after our modification time (which download sets to the
+// Last-Modified of what it got).
+func (f *Fetcher) headChanged(ctx context.Context, url string, info os.FileInfo) (bool, error) {
+	if f.Limiter != nil {
+		if err := f.Limiter.Wait(ctx); err != nil {
+			return false, err
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, f.Timeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return false, fmt.Errorf("error creating request: %v", err)
+	}
+	response, err := f.Client.Do(request)
+	if err != nil {
+		return false, err
+	}
+	response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return false, fmt.Errorf("server returned %v", response.Status)
+	}
+	// Our size is no use if we compressed it.
+	if response.ContentLength >= 0 && !f.Compress && response.ContentLength != info.Size() {
+		return true, nil
+	}
+	if modified, err := http.ParseTime(response.Header.Get("Last-Modified")); err == nil &&
+		modified.After(info.ModTime()) {
+		return true, nil
+	}
+	return false, nil
+}
+
+// Returns the formats to download doc in. Charters only come in one, and
+// RFCs are only downloaded with f.IncludeRFCs.
+func (f *Fetcher) docFormats(doc Doc) []string {
+	switch {
+	case doc.Kind == KindCharter:
+		return []string{"txt"}
+	case doc.Kind == KindRFC && !f.IncludeRFCs:
+		return nil
+	}
+	return f.Formats
+}
+
+// Replaced by the document's name (see Doc.Name) in --url-template URLs.
+const NamePlaceholder = "{name}"
+
+// Returns where to download doc in format from, what the servers call it (and
+// so what to ask mirrors for), and where to put it, which with f.Flat or
+// f.Compress isn't the same name.
+// f.URLTemplates beats f.DocBaseURL, which beats the format's own urlPrefix.
+func (f *Fetcher) target(date string, doc Doc, format string) (url string, filename string, fullname string) {
+	known := formats
+	switch doc.Kind {
+	case KindCharter:
+		known = charterFormats
+	case KindRFC:
+		known = rfcFormats
+	}
+	filename = doc.Name() + known[format].extension
+	fullname = filepath.Join(f.Dir(date), f.Prefix(date)+filename+f.suffix())
+	if template, ok := f.URLTemplates[format]; ok {
+		return strings.ReplaceAll(template, NamePlaceholder, doc.Name()), filename, fullname
+	}
+	prefix := known[format].urlPrefix
+	if f.DocBaseURL != "" {
+		prefix = strings.TrimSuffix(f.DocBaseURL, "/") + "/"
+	}
+	return prefix + filename, filename, fullname
+}
+
+// Copies the document at src (see findElsewhere) to fullname, setting
+// result.Bytes and result.SHA256.
+func (f *Fetcher) copyFrom(ctx context.Context, src string, fullname string, result *Result) error {
+	input, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+	info, err := input.Stat()
+	if err != nil {
+		return err
+	}
+	output, err := f.Storage.Create(ctx, fullname, false)
+	if err != nil {
+		return err
+	}
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(output, hash), input)
+	if cerr := output.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		f.Storage.Discard(ctx, fullname)
+		return err
+	}
+	if err := f.Storage.Finalize(ctx, fullname, info.ModTime()); err != nil {
+		return err
+	}
+	result.Bytes, result.SHA256 = n, fmt.Sprintf("%x", hash.Sum(nil))
+	return nil
+}
+
+// Returns the path of filename for another telechat than date (the most
+// recent, if there are several), or "" if there isn't one.
+func (f *Fetcher) findElsewhere(date string, filename string) string {
+	own, _ := time.Parse(DateLayout, date)
+	dated, _ := f.dated()
+	found, foundDate := "", time.Time{}
+	for _, other := range dated {
+		if other.date.Equal(own) {
+			continue
+		}
+		path := filepath.Join(other.path, filename)
+		if f.Flat {
+			if filepath.Base(other.path)[len(DateLayout)+1:] != filename {
+				continue
+			}
+			path = other.path
+		}
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		if found == "" || other.date.After(foundDate) {
+			found, foundDate = path, other.date
+		}
+	}
+	return found
+}
+
+// Returns the telechat directories in f.BaseDir, or with f.Flat, the
+// telechat files.
+func (f *Fetcher) dated() ([]datedPath, error) {
+	if f.Flat {
+		return flatFiles(f.BaseDir)
+	}
+	return dateDirs(f.BaseDir, f.DateLayout)
+}
+
+// Removes telechats older than age, see prune.
+func (f *Fetcher) Prune(age time.Duration, dryRun bool) error {
+	dated, err := f.dated()
+	if err != nil {
+		return err
+	}
+	return prune(f.BaseDir, dated, age, time.Now(), dryRun)
+}
+
+// Returns what the names of our files for the telechat on date start with:
+// nothing, unless f.Flat puts them all in one directory.
+func (f *Fetcher) Prefix(date string) string {
+	if f.Flat {
+		return date + "-"
+	}
+	return ""
+}
+
+// Returns what the names of our files end with, after the format's extension.
+func (f *Fetcher) suffix() string {
+	if f.Compress {
+		return GzipSuffix
+	}
+	return ""
+}
+
+// Returns the directory for the telechat on date.
+func (f *Fetcher) Dir(date string) string {
+	if f.Flat {
+		return f.BaseDir
+	}
+	return filepath.Join(f.BaseDir, dateDir(date, f.DateLayout))
+}
+
+// Writes to w what FetchDocs would download, and where to, without doing it.
+func (f *Fetcher) DryRun(w io.Writer, documents map[string][]Doc) {
+	var dates []string
+	for date := range documents {
+		dates = append(dates, date)
+	}
+	sort.Strings(dates)
+	for _, date := range dates {
+		fmt.Fprintf(w, "%v: %d document(s)\n", date, len(documents[date]))
+		docs := documents[date]
+		for i, doc := range docs {
+			if i == 0 || doc.Section != docs[i-1].Section {
+				fmt.Fprintf(w, " %v %v\n", doc.Section, doc.SectionTitle)
+			}
+			if !doc.downloadable() {
+				continue
+			}
+			for _, format := range f.docFormats(doc) {
+				url, _, fullname := f.target(date, doc, format)
+				fmt.Fprintf(w, "  %v -> %v\n", url, f.Storage.Location(fullname))
+			}
+			for _, name := range f.docExtras(doc) {
+				url, fullname := f.extraTarget(date, doc, name)
+				fmt.Fprintf(w, "  %v -> %v\n", url, f.Storage.Location(fullname))
+			}
+		}
+	}
+}
+
+// What happened when we tried to fetch one document in one format.
+type Result struct {
+	Date   string
+	Doc    string // docname-rev
+	Format string
+	File   string // The name of our copy, in Fetcher.Dir(Date).
+	URL    string
+	Bytes  int64 // The size of the document.
+	// How many of Bytes we received this time, which is fewer if the download
+	// was resumed.
+	Received int64
+	Attempts int
+	Err      error // Set if the download failed.
+	Skipped  bool  // We already had it (or the server said it hadn't changed).
+	// If we hardlinked our copy from another telechat's, rather than
+	// downloading it, the path of that.
+	LinkedFrom string
+	// Or copied it (with --no-clobber-across-dates), the path of that.
+	CopiedFrom string
+	// If one of the --mirror servers sent it, that mirror.
+	Mirror string
+	// We didn't download it because it is bigger than --max-size. Bytes is
+	// its size, if the server said.
+	TooLarge bool
+	// It's an extra (see Fetcher.Extras) the document doesn't have.
+	Missing bool
+
+	// From the server, to make later downloads conditional.
+	ETag         string
+	LastModified string
+	// Hex SHA-256 of our copy, for SumsName.
+	SHA256 string
+	// Set if the --post-hook failed for it (the download itself worked).
+	HookErr error
+	// The other sections of the agenda it is listed in, see Doc.AlsoIn.
+	AlsoIn []string
+}
+
+// Fetches a single document, puts it in the directory specified by date.
+// Notifies we are done by posting to done!
+// Documents which are already on disk are skipped, unless f.Overwrite is set,
+// in which case we only download them again if the server says they have
+// changed since previous (the manifest entry from the last run).
+// With f.Verify, documents already on disk which don't match the checksum in
+// previous are downloaded again, and so (with f.CheckHead) are ones the
+// server's HEAD response says have changed, see headChanged.
+// Failed downloads are retried up to f.Retries times, waiting f.RetryDelay
+// before the first retry and doubling the wait each time after that.
+func (f *Fetcher) fetchDoc(ctx context.Context, date string, doc Doc, format string,
+	previous ManifestEntry, done chan Result) {
+
+	url, filename, fullname := f.target(date, doc, format)
+	result := Result{Date: date, Doc: doc.Name(), Format: format, URL: url, File: filepath.Base(fullname), AlsoIn: doc.AlsoIn}
+
+	// If this fails because the file already exists, we are done!
+	info, exists, err := f.Storage.Exists(ctx, fullname)
+	if err != nil {
+		// Carry on as if we don't have it, which at worst downloads it again.
+		log.Warnf("Can't tell if we have %v: %v", f.Storage.Location(fullname), err)
+	}
+	if exists {
+		result.ETag, result.LastModified = previous.ETag, previous.LastModified
+		result.SHA256 = previous.SHA256
+		corrupt := false
+		if result.SHA256 == "" || f.Verify {
+			sum, err := hashStored(ctx, f.Storage, fullname)
+			switch {
+			case err != nil:
+				log.Warnf("Error checksumming %v: %v", f.Storage.Location(fullname), err)
+			case result.SHA256 != "" && sum != result.SHA256:
+				log.Warnf("%v doesn't match its checksum, downloading it again", f.Storage.Location(fullname))
+				// Don't let the server tell us our (bad) copy is current.
+				corrupt, result.ETag, result.LastModified = true, "", ""
+			default:
+				result.SHA256 = sum
+			}
+		}
+		changed := corrupt
+		if f.CheckHead && !corrupt && !f.Overwrite {
+			var err error
+			if changed, err = f.headChanged(ctx, url, info); err != nil {
+				log.Warnf("Can't check %v, keeping our copy: %v", url, err)
+			} else if changed {
+				log.Infof("%v has changed, downloading it again", url)
+				result.ETag, result.LastModified = "", ""
+			}
+		}
+		if !f.Overwrite && !changed {
+			result.Skipped, result.Bytes = true, info.Size()
+			done <- result
+			return
+		}
+	} else if f.Hardlink || f.NoClobber {
+		if src := f.findElsewhere(date, strings.TrimPrefix(filepath.Base(fullname), f.Prefix(date))); src != "" {
+			// Not all filesystems can, in which case we copy or download it.
+			if f.Hardlink {
+				lerr := os.Link(src, fullname)
+				if lerr == nil {
+					result.LinkedFrom = src
+					if info, err := os.Stat(fullname); err == nil {
+						result.Bytes = info.Size()
+					}
+					if sum, err := hashFile(fullname); err == nil {
+						result.SHA256 = sum
+					}
+					done <- result
+					return
+				}
+				log.Infof("Can't link %v to %v: %v", src, fullname, lerr)
+			}
+			if f.NoClobber {
+				cerr := f.copyFrom(ctx, src, fullname, &result)
+				if cerr == nil {
+					result.CopiedFrom = src
+					done <- result
+					return
+				}
+				log.Warnf("Can't copy %v to %v: %v", src, fullname, cerr)
+			}
+			log.Infof("Downloading %v instead", url)
+		}
+	}
+
+	delay := f.RetryDelay
+	for attempt := 1; ; attempt++ {
+		result.Attempts = attempt
+		result.Err = f.downloadAny(ctx, url, filename, fullname, &result)
+		if result.Err == errNotModified {
+			result.Err, result.Skipped, result.Bytes = nil, true, info.Size()
+		}
+		if result.Err == errTooLarge {
+			result.Err, result.TooLarge = nil, true
+		}
+		if result.Err == nil && !result.Skipped && !result.TooLarge && f.PostHook != "" {
+			if result.HookErr = f.runHook(ctx, date, doc.Name(), format, fullname); result.HookErr != nil {
+				log.Warnf("Post-hook failed for %v: %v", fullname, result.HookErr)
+			}
+		}
+		if result.Err == nil || attempt > f.Retries || ctx.Err() != nil {
+			done <- result
+			return
+		}
+		log.Infof("Attempt %d for %v failed, retrying in %v: %v", attempt, url, delay, result.Err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+		}
+		delay *= 2
+	}
+}
+
+// Fetches documents in parallel.
+//
+// Takes a map of slices, {"date": [doc1, doc2]} and
+// gets the documents, once in each of the formats, see fetchDate.
+//
+// Returns a Result for each document in each format, grouped by date (in
+// date order). If we can't make the directory for a date, its documents are
+// left out, the other dates carry on, and the error says which dates were left
+// out.
+//
+// No more than f.DateParallelism dates are fetched at the same time, each
+// with its own f.Parallelism downloads, so one big telechat can't hold up the
+// others.
+func (f *Fetcher) FetchDocs(ctx context.Context, telechats map[string][]Doc) ([]Result, error) {
+
+	// Make directories if not already exist
+	documents := make(map[string][]Doc)
+	var failed []string
+	for date, docs := range telechats {
+		dir := f.Dir(date)
+		if err := os.MkdirAll(dir, f.DirMode); err != nil {
+			log.Errorf("Error making %v, skipping the %v telechat: %v", dir, date, err)
+			failed = append(failed, date)
+			continue
+		}
+		documents[date] = docs
+	}
+	var err error
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		err = fmt.Errorf("couldn't make the directories for %v", strings.Join(failed, ", "))
+	}
+
+	// Each date holds a slot in here while its documents download.
+	slots := make(chan struct{}, f.DateParallelism)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	byDate := make(map[string][]Result)
+	for date, docs := range documents {
+		wg.Add(1)
+		go func(date string, docs []Doc) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			results := f.fetchDate(ctx, date, docs)
+			mu.Lock()
+			byDate[date] = results
+			mu.Unlock()
+		}(date, docs)
+	}
+	wg.Wait()
+
+	var dates []string
+	for date := range byDate {
+		dates = append(dates, date)
+	}
+	sort.Strings(dates)
+	var items []Result
+	for _, date := range dates {
+		items = append(items, byDate[date]...)
+	}
+	return items, err
+}
+
+// Fetches the documents for the telechat on date, in parallel, into its
+// (already existing) directory. Writes a manifest (see writeManifest),
+// checksums (see writeSums) and indexes (see writeIndex and writeHTMLIndex)
+// into the directory once they are done.
+//
+// No more than f.Parallelism downloads run at the same time, and each one is
+// retried as described in fetchDoc.
+func (f *Fetcher) fetchDate(ctx context.Context, date string, docs []Doc) []Result {
+	dir, prefix := f.Dir(date), f.Prefix(date)
+
+	// What we got last time, for conditional downloads.
+	previous := make(map[string]ManifestEntry)
+	if m, err := ReadManifest(dir, prefix); err == nil {
+		for _, entry := range m.Downloads {
+			previous[entry.Doc+"/"+entry.Format] = entry
+		}
+	}
+	// The checksums file is what --verify checks against.
+	if sums, err := readSums(dir, prefix); err == nil {
+		for key, entry := range previous {
+			entry.SHA256 = sums[prefix+entry.Doc+extension(entry.Format)+f.suffix()]
+			previous[key] = entry
+		}
+	}
+
+	// Channels
+	doccount := 0
+	var items []Result
+	// Where each download's result goes in items (by docname-rev/format),
+	// which is the order of docs.
+	order := make(map[string]int)
+	// The documents we've started downloading, by Doc.Name.
+	started := make(map[string]bool)
+	channel := make(chan Result)
+	// Each download holds a slot in here while it runs.
+	slots := make(chan struct{}, f.Parallelism)
+	for _, doc := range docs {
+		if !doc.downloadable() {
+			continue
+		}
+		// Agenda.Docs doesn't return a document twice, but callers of
+		// FetchDocs might, and we'd wait for a result that never comes.
+		if started[doc.Name()] {
+			log.Infof("%v is listed more than once for %v, only fetching it once", doc.Name(), date)
+			continue
+		}
+		started[doc.Name()] = true
+		for _, format := range f.docFormats(doc) {
+			go func(doc Doc, format string, previous ManifestEntry) {
+				slots <- struct{}{}
+				defer func() { <-slots }()
+				f.fetchDoc(ctx, date, doc, format, previous, channel)
+			}(doc, format, previous[doc.Name()+"/"+format])
+			order[doc.Name()+"/"+format] = doccount
+			doccount++
+		}
+		for _, name := range f.docExtras(doc) {
+			go func(doc Doc, name string) {
+				slots <- struct{}{}
+				defer func() { <-slots }()
+				f.fetchExtra(ctx, date, doc, name, channel)
+			}(doc, name)
+			order[doc.Name()+"/"+name] = doccount
+			doccount++
+		}
+	}
+	// Every fetchDoc sends exactly one result, and gives up on its own if a
+	// download takes too long (see download), so this always finishes, and
+	// any timeout is that document's. Nothing else is counted, so there are
+	// never more than doccount items.
+	got := make(map[string]bool)
+	for len(items) < doccount {
+		downloaded := <-channel
+		key := downloaded.Doc + "/" + downloaded.Format
+		if _, ok := order[key]; !ok || got[key] {
+			log.Errorf("Ignoring unexpected result for %v (%v)", downloaded.Doc, downloaded.Format)
+			continue
+		}
+		got[key] = true
+		items = append(items, downloaded)
+		if f.Progress != nil {
+			fmt.Fprintf(f.Progress, "[%d/%d] %v\n", len(items), doccount, FormatResult(downloaded))
+		}
+	}
+	close(channel)
+	// They finish in whatever order, but everything after should be the
+	// same every time.
+	sort.Slice(items, func(i, j int) bool {
+		return order[items[i].Doc+"/"+items[i].Format] < order[items[j].Doc+"/"+items[j].Format]
+	})
+
+	if err := writeManifest(dir, prefix, date, docs, items); err != nil {
+		log.Errorf("Error writing manifest for %v: %v", date, err)
+	}
+	if err := writeSums(dir, prefix, date, items); err != nil {
+		log.Errorf("Error writing %v for %v: %v", SumsName, date, err)
+	}
+	if err := writeIndex(dir, prefix, date, docs, items); err != nil {
+		log.Errorf("Error writing index for %v: %v", date, err)
+	}
+	if err := writeHTMLIndex(dir, prefix, date, docs, items); err != nil {
+		log.Errorf("Error writing HTML index for %v: %v", date, err)
+	}
+	return items
+}
+
+// Describes result for the user.
+func FormatResult(r Result) string {
+	if r.HookErr != nil {
+		hookErr := r.HookErr
+		r.HookErr = nil
+		return fmt.Sprintf("%v Post-hook failed: %v", FormatResult(r), hookErr)
+	}
+	if len(r.AlsoIn) > 0 {
+		alsoIn := r.AlsoIn
+		r.AlsoIn = nil
+		return fmt.Sprintf("%v Also listed in %v.", FormatResult(r), strings.Join(alsoIn, ", "))
+	}
+	// Our copy's name, which (with Flat or Compress) isn't just the document's.
+	filename := r.File
+	switch {
+	case r.Err != nil && r.Doc == "":
+		return fmt.Sprintf("Error: %v", r.Err)
+	case r.Err != nil:
+		return fmt.Sprintf("Error while downloading %v (%v), %d attempt(s) - %v", r.URL, r.Format, r.Attempts, r.Err)
+	case r.TooLarge:
+		return fmt.Sprintf("%v: Skipped %v (%v), too large.", r.Date, filename, r.Format)
+	case r.Missing:
+		return fmt.Sprintf("%v: No %v for %v.", r.Date, r.Format, r.Doc)
+	case r.Skipped:
+		return fmt.Sprintf("%v: %v (%v) already existed.", r.Date, filename, r.Format)
+	case r.LinkedFrom != "":
+		return fmt.Sprintf("%v: Linked %v (%v) from %v.", r.Date, filename, r.Format, r.LinkedFrom)
+	case r.CopiedFrom != "":
+		return fmt.Sprintf("%v: Copied %v (%v) from %v.", r.Date, filename, r.Format, r.CopiedFrom)
+	case r.Mirror != "":
+		return fmt.Sprintf("%v: Downloaded %s (%s) from %v: %d bytes, %d attempt(s).", r.Date, filename, r.Format, r.Mirror, r.Bytes, r.Attempts)
+	default:
+		return fmt.Sprintf("%v: Downloaded %s (%s): %d bytes, %d attempt(s).", r.Date, filename, r.Format, r.Bytes, r.Attempts)
+	}
+}
@@ -0,0 +1,185 @@
+package telechat
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const kTestDate = "2024-06-13"
+
+// Serves a PDF, a 404, and an HTML error page pretending to be a PDF.
+func testServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/draft-good-01.pdf":
+			w.Header().Set("Content-Type", "application/pdf")
+			io.WriteString(w, "%PDF-1.4\nnot really, but close enough\n%%EOF\n")
+		case "/draft-html-01.pdf":
+			w.Header().Set("Content-Type", "text/html")
+			io.WriteString(w, "<html><body>Sorry, try again later</body></html>\n")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// Returns the results from f fetching docs, by Result.Doc.
+func fetch(t *testing.T, f *Fetcher, docs []Doc) map[string]Result {
+	results, err := f.FetchDocs(context.Background(), map[string][]Doc{kTestDate: docs})
+	if err != nil {
+		t.Fatalf("FetchDocs returned error %v", err)
+	}
+	byDoc := make(map[string]Result)
+	for _, result := range results {
+		byDoc[result.Doc] = result
+	}
+	if len(byDoc) != len(docs) {
+		t.Fatalf("FetchDocs returned %d results for %d documents", len(byDoc), len(docs))
+	}
+	return byDoc
+}
+
+func TestFetchDocs(t *testing.T) {
+	srv := testServer(t)
+	f := NewFetcher(srv.Client(), t.TempDir())
+	f.DocBaseURL = srv.URL
+	docs := []Doc{
+		{Docname: "draft-good", Rev: "01"},
+		{Docname: "draft-missing", Rev: "01"},
+		{Docname: "draft-html", Rev: "01"},
+	}
+
+	results := fetch(t, f, docs)
+	good := results["draft-good-01"]
+	if good.Err != nil || good.Skipped {
+		t.Errorf("draft-good-01: got %+v, want it downloaded", good)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(f.Dir(kTestDate), "draft-good-01.pdf"))
+	if err != nil {
+		t.Fatalf("draft-good-01.pdf wasn't written: %v", err)
+	}
+	if !strings.HasPrefix(string(data), "%PDF-") {
+		t.Errorf("draft-good-01.pdf is %q, want the PDF", data)
+	}
+	for _, name := range []string{"draft-missing-01", "draft-html-01"} {
+		if results[name].Err == nil {
+			t.Errorf("%v: got %+v, want an error", name, results[name])
+		}
+	}
+	// Nothing (not even a partial copy) should be left of the failures.
+	leftovers, err := filepath.Glob(filepath.Join(f.Dir(kTestDate), "draft-[mh]*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leftovers) > 0 {
+		t.Errorf("failed downloads left %v behind", leftovers)
+	}
+
+	// We already have it, so it isn't downloaded again.
+	results = fetch(t, f, docs)
+	if again := results["draft-good-01"]; again.Err != nil || !again.Skipped {
+		t.Errorf("draft-good-01 the second time: got %+v, want it skipped", again)
+	}
+}
+
+// The same document twice gets one result, rather than waiting for ever for
+// the second.
+func TestFetchDocsTwice(t *testing.T) {
+	srv := testServer(t)
+	f := NewFetcher(srv.Client(), t.TempDir())
+	f.DocBaseURL = srv.URL
+	doc := Doc{Docname: "draft-good", Rev: "01"}
+
+	done := make(chan []Result, 1)
+	go func() {
+		results, _ := f.FetchDocs(context.Background(), map[string][]Doc{kTestDate: {doc, doc}})
+		done <- results
+	}()
+	select {
+	case results := <-done:
+		if len(results) != 1 || results[0].Err != nil {
+			t.Errorf("got %+v, want draft-good-01 downloaded once", results)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("FetchDocs didn't return")
+	}
+}
+
+// Mirrors are asked for the document by the name the servers know it by, not
+// what we call our copy.
+func TestFetchDocsMirror(t *testing.T) {
+	mirror := testServer(t)
+	primary := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(primary.Close)
+
+	tests := []struct {
+		name  string
+		setup func(f *Fetcher)
+		file  string
+	}{
+		{"plain", func(f *Fetcher) {}, "draft-good-01.pdf"},
+		{"flat", func(f *Fetcher) { f.Flat = true }, kTestDate + "-draft-good-01.pdf"},
+		{"compressed", func(f *Fetcher) { f.Compress = true }, "draft-good-01.pdf" + GzipSuffix},
+	}
+	for _, test := range tests {
+		f := NewFetcher(primary.Client(), t.TempDir())
+		f.DocBaseURL = primary.URL
+		f.Mirrors = []string{mirror.URL}
+		test.setup(f)
+
+		result := fetch(t, f, []Doc{{Docname: "draft-good", Rev: "01"}})["draft-good-01"]
+		if result.Err != nil || result.Mirror != mirror.URL {
+			t.Errorf("%v: got %+v, want it from the mirror", test.name, result)
+			continue
+		}
+		if result.File != test.file {
+			t.Errorf("%v: stored as %v, want %v", test.name, result.File, test.file)
+		}
+		data, err := f.readStored(context.Background(), filepath.Join(f.Dir(kTestDate), test.file))
+		if err != nil || !strings.HasPrefix(string(data), "%PDF-") {
+			t.Errorf("%v: our copy is %q (%v), want the PDF", test.name, data, err)
+		}
+	}
+}
+
+// A resumed download's Bytes is the size of the whole document, and Received
+// just what came this time.
+func TestFetchDocsResume(t *testing.T) {
+	doc := []byte("%PDF-1.4\n" + strings.Repeat("resume me\n", 100) + "%%EOF\n")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/pdf")
+		http.ServeContent(w, r, "draft-good-01.pdf", time.Time{}, bytes.NewReader(doc))
+	}))
+	t.Cleanup(srv.Close)
+	f := NewFetcher(srv.Client(), t.TempDir())
+	f.DocBaseURL = srv.URL
+
+	// As if an earlier attempt got this far.
+	if err := os.MkdirAll(f.Dir(kTestDate), 0755); err != nil {
+		t.Fatal(err)
+	}
+	fullname := filepath.Join(f.Dir(kTestDate), "draft-good-01.pdf")
+	if err := ioutil.WriteFile(fullname+kPartSuffix, doc[:100], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result := fetch(t, f, []Doc{{Docname: "draft-good", Rev: "01"}})["draft-good-01"]
+	if result.Err != nil || result.Bytes != int64(len(doc)) || result.Received != int64(len(doc)-100) {
+		t.Errorf("got %+v, want Bytes %d and Received %d", result, len(doc), len(doc)-100)
+	}
+	data, err := ioutil.ReadFile(fullname)
+	if err != nil || !bytes.Equal(data, doc) {
+		t.Errorf("our copy is %q (%v), want the whole document", data, err)
+	}
+}
@@ -0,0 +1,57 @@
+package telechat
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Returns documents with only the docs for which keep returns true. Dates
+// which end up with no documents are kept, with an empty list.
+// Management items have no AD, status or docname to filter on, so they are
+// always kept.
+func FilterDocs(documents map[string][]Doc, keep func(Doc) bool) map[string][]Doc {
+	result := make(map[string][]Doc)
+	for date, docs := range documents {
+		result[date] = []Doc{}
+		for _, doc := range docs {
+			if doc.Kind == KindManagement || keep(doc) {
+				result[date] = append(result[date], doc)
+			}
+		}
+	}
+	return result
+}
+
+// Reports whether value is one of values, ignoring case and surrounding space.
+func matchAny(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(strings.TrimSpace(v), value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns a filter which keeps documents whose responsible AD is one of ads
+// (ignoring case).
+func ADFilter(ads []string) func(Doc) bool {
+	return func(doc Doc) bool {
+		return matchAny(ads, doc.AD)
+	}
+}
+
+// Returns a filter which keeps documents whose intended status is one of
+// statuses (ignoring case), e.g. "Proposed Standard".
+func StatusFilter(statuses []string) func(Doc) bool {
+	return func(doc Doc) bool {
+		return matchAny(statuses, doc.IntendedStatus)
+	}
+}
+
+// Returns a filter which keeps documents whose name matches re, or with
+// exclude, those whose name doesn't.
+func NameFilter(re *regexp.Regexp, exclude bool) func(Doc) bool {
+	return func(doc Doc) bool {
+		return re.MatchString(doc.Docname) != exclude
+	}
+}
@@ -0,0 +1,39 @@
+package telechat
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestFilterDocs(t *testing.T) {
+	docs := map[string][]Doc{kTestDate: {
+		{Docname: "draft-ietf-dnsop-foo", Rev: "03", AD: "Warren Kumari", IntendedStatus: "Proposed Standard"},
+		{Docname: "draft-bar", Rev: "01", AD: "Some One", IntendedStatus: "Informational"},
+		{Kind: KindManagement, Section: "6.1", SectionTitle: "Designated experts"},
+	}}
+
+	tests := []struct {
+		name string
+		keep func(Doc) bool
+		want []string
+	}{
+		{"ad", ADFilter([]string{"warren kumari"}), []string{"draft-ietf-dnsop-foo-03", "6.1"}},
+		{"status", StatusFilter([]string{"Informational"}), []string{"draft-bar-01", "6.1"}},
+		{"name", NameFilter(regexp.MustCompile("dnsop"), false), []string{"draft-ietf-dnsop-foo-03", "6.1"}},
+		{"name exclude", NameFilter(regexp.MustCompile("dnsop"), true), []string{"draft-bar-01", "6.1"}},
+	}
+	for _, test := range tests {
+		var got []string
+		for _, doc := range FilterDocs(docs, test.keep)[kTestDate] {
+			if doc.Kind == KindManagement {
+				got = append(got, doc.Section)
+			} else {
+				got = append(got, doc.Name())
+			}
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: kept %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package telechat
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Runs the --post-hook command on path, the doc we just downloaded in format
+// for the telechat on date. The command is run by the shell with path as its
+// argument, and with TELECHAT_DATE, TELECHAT_DOC, TELECHAT_FORMAT and
+// TELECHAT_FILE in its environment. It's killed if it takes longer than
+// f.HookTimeout.
+func (f *Fetcher) runHook(ctx context.Context, date string, doc string, format string, path string) error {
+	ctx, cancel := context.WithTimeout(ctx, f.HookTimeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", f.PostHook+` "`+path+`"`)
+	} else {
+		// path goes on the end, and is also $1 for commands which want it elsewhere.
+		cmd = exec.CommandContext(ctx, "sh", "-c", f.PostHook+` "$@"`, "sh", path)
+	}
+	cmd.Env = append(os.Environ(),
+		"TELECHAT_DATE="+date,
+		"TELECHAT_DOC="+doc,
+		"TELECHAT_FORMAT="+format,
+		"TELECHAT_FILE="+path)
+	// Don't wait for anything it started (which has our output) once it's
+	// been killed.
+	cmd.WaitDelay = time.Second
+	output, err := cmd.CombinedOutput()
+	if len(output) > 0 {
+		log.Debugf("Post-hook for %v said: %s", path, output)
+	}
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		return fmt.Errorf("timeout (%v)", f.HookTimeout)
+	case err != nil && len(output) > 0:
+		// The last thing it said is usually why.
+		lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+		return fmt.Errorf("%v: %v", err, lines[len(lines)-1])
+	}
+	return err
+}
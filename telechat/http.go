@@ -0,0 +1,42 @@
+package telechat
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Asks for request's response to be gzipped. We do this (and uncompress it
+// in responseBody) ourselves rather than leaving it to the transport, which
+// quietly stops doing it as soon as anything sets Accept-Encoding.
+// Not for Range requests, as the range would be of the compressed bytes.
+func acceptGzip(request *http.Request) {
+	request.Header.Set("Accept-Encoding", "gzip")
+}
+
+// A gzipped response body, closing the response's when it's closed.
+type gzipBody struct {
+	*gzip.Reader
+	body io.ReadCloser
+}
+
+func (b *gzipBody) Close() error {
+	b.Reader.Close()
+	return b.body.Close()
+}
+
+// Returns response's body, uncompressed if the server gzipped it, and whether
+// it did. If so response.ContentLength is the compressed size, so no use for
+// checking what we read.
+func responseBody(response *http.Response) (io.ReadCloser, bool, error) {
+	if !strings.EqualFold(response.Header.Get("Content-Encoding"), "gzip") {
+		return response.Body, false, nil
+	}
+	reader, err := gzip.NewReader(response.Body)
+	if err != nil {
+		return nil, false, fmt.Errorf("bad gzipped response: %v", err)
+	}
+	return &gzipBody{reader, response.Body}, true, nil
+}
@@ -0,0 +1,137 @@
+package telechat
+
+import (
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// The name of the Markdown index in each date directory.
+	kIndexName = "README.md"
+
+	// And of the HTML one.
+	kHTMLIndexName = "index.html"
+
+	// The datatracker page for a document, given the docname and rev.
+	kDatatrackerDocURL = "https://datatracker.ietf.org/doc/%s/%s"
+)
+
+// Returns the datatracker page for doc.
+func DatatrackerURL(doc Doc) string {
+	return strings.TrimSuffix(fmt.Sprintf(kDatatrackerDocURL, doc.Docname, doc.Rev), "/") + "/"
+}
+
+// Returns the local files results say we have for the telechat on date, by
+// document name.
+func localFiles(date string, results []Result) map[string][]string {
+	have := make(map[string][]string)
+	for _, result := range results {
+		if result.Date == date && result.Err == nil && !result.TooLarge && !result.Missing {
+			have[result.Doc] = append(have[result.Doc], result.File)
+		}
+	}
+	return have
+}
+
+// Writes a Markdown table of contents for the telechat on date into dir,
+// linking each of docs to its datatracker page and to whichever local copies
+// results say we have.
+func writeIndex(dir string, prefix string, date string, docs []Doc, results []Result) error {
+	have := localFiles(date, results)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# IESG telechat %v\n\n", date)
+	fmt.Fprintf(&b, "| Document | Datatracker | Local copies | Notes |\n")
+	fmt.Fprintf(&b, "|---|---|---|---|\n")
+	for _, doc := range docs {
+		if doc.Kind == KindManagement {
+			fmt.Fprintf(&b, "| %v %v (management item) | | | |\n", doc.Section, doc.SectionTitle)
+			continue
+		}
+		var files []string
+		for _, file := range have[doc.Name()] {
+			// E.g. "pdf", or "writeup.txt".
+			label := strings.TrimPrefix(strings.TrimSuffix(file, GzipSuffix), prefix+doc.Name()+".")
+			files = append(files, fmt.Sprintf("[%v](%v)", label, file))
+		}
+		fmt.Fprintf(&b, "| %v | [datatracker](%v) | %v | %v |\n",
+			doc.Name(), DatatrackerURL(doc), strings.Join(files, " "), strings.ReplaceAll(doc.Notes(), "|", "\\|"))
+	}
+	return ioutil.WriteFile(filepath.Join(dir, prefix+kIndexName), []byte(b.String()), 0644)
+}
+
+var htmlIndex = template.Must(template.New(kHTMLIndexName).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>IESG telechat {{.Date}}</title>
+</head>
+<body>
+<h1>IESG telechat {{.Date}}</h1>
+{{range .Sections}}
+<h2>{{.Number}} {{.Title}}</h2>
+<ul>
+{{- range .Docs}}
+<li>{{.Name}} (<a href="{{.Datatracker}}">datatracker</a>){{range .Files}} <a href="{{.}}">{{.}}</a>{{end}}{{with .Notes}} <em>{{.}}</em>{{end}}</li>
+{{- end}}
+</ul>
+{{end}}
+</body>
+</html>
+`))
+
+// Writes an HTML page for the telechat on date into dir, linking each of docs
+// to its datatracker page and local copies, grouped by agenda section.
+func writeHTMLIndex(dir string, prefix string, date string, docs []Doc, results []Result) error {
+	type htmlDoc struct {
+		Name        string
+		Datatracker string
+		Files       []string
+		Notes       string
+	}
+	type htmlSection struct {
+		Number string
+		Title  string
+		Docs   []htmlDoc
+	}
+
+	have := localFiles(date, results)
+	sections := make(map[string]*htmlSection)
+	var numbers []string
+	for _, doc := range docs {
+		section, ok := sections[doc.Section]
+		if !ok {
+			section = &htmlSection{Number: doc.Section, Title: doc.SectionTitle}
+			sections[doc.Section] = section
+			numbers = append(numbers, doc.Section)
+		}
+		if doc.Kind == KindManagement {
+			continue
+		}
+		section.Docs = append(section.Docs, htmlDoc{doc.Name(), DatatrackerURL(doc), have[doc.Name()], doc.Notes()})
+	}
+	sort.Slice(numbers, func(i, j int) bool { return sectionLess(numbers[i], numbers[j]) })
+
+	page := struct {
+		Date     string
+		Sections []*htmlSection
+	}{Date: date}
+	for _, number := range numbers {
+		page.Sections = append(page.Sections, sections[number])
+	}
+
+	output, err := os.Create(filepath.Join(dir, prefix+kHTMLIndexName))
+	if err != nil {
+		return err
+	}
+	if err := htmlIndex.Execute(output, page); err != nil {
+		output.Close()
+		return err
+	}
+	return output.Close()
+}
@@ -0,0 +1,41 @@
+package telechat
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Points basedir/latest at the directory for the most recent of the dates
+// (named using layout, see dateDir).
+// Where we can't make symlinks (Windows, usually) we write the date into
+// basedir/latest.txt instead.
+func UpdateLatest(basedir string, layout string, dates []string) error {
+	if len(dates) == 0 {
+		return nil
+	}
+	// YYYY-MM-DD sorts nicely.
+	latest := dates[0]
+	for _, date := range dates[1:] {
+		if date > latest {
+			latest = date
+		}
+	}
+
+	if runtime.GOOS != "windows" {
+		// Make the new link on the side and rename it over the old one, so
+		// there is always a latest.
+		link := filepath.Join(basedir, LatestName)
+		tmp := link + ".new"
+		os.Remove(tmp)
+		err := os.Symlink(dateDir(latest, layout), tmp)
+		if err == nil {
+			return os.Rename(tmp, link)
+		}
+		log.Infof("Can't symlink %v, writing %v instead: %v", link, LatestName+".txt", err)
+	}
+	return ioutil.WriteFile(filepath.Join(basedir, LatestName+".txt"), []byte(latest+"\n"), 0644)
+}
@@ -0,0 +1,150 @@
+package telechat
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+)
+
+// The name of the manifest in each date directory.
+const kManifestName = "manifest.json"
+
+// Values for ManifestEntry.Status.
+const (
+	kStatusDownloaded = "downloaded"
+	kStatusExisted    = "existed"
+	kStatusLinked     = "linked"
+	kStatusCopied     = "copied" // From another date, see --no-clobber-across-dates.
+	kStatusFailed     = "failed"
+	kStatusTooLarge   = "too-large" // Bigger than --max-size, so not downloaded.
+	kStatusMissing    = "none"      // An extra (e.g. a writeup) the document doesn't have.
+)
+
+// A machine readable record of what was on a telechat, and what happened
+// when we tried to download it.
+type Manifest struct {
+	Date      string            `json:"telechat-date"`
+	Documents []string          `json:"documents"` // docname-rev, in agenda order.
+	Sections  []ManifestSection `json:"sections"`
+	// The agenda's notes (see Doc.Notes), by docname-rev.
+	Notes     map[string]string `json:"notes,omitempty"`
+	Downloads []ManifestEntry   `json:"downloads"`
+}
+
+// The documents in one section of the agenda.
+type ManifestSection struct {
+	Number    string   `json:"number"`
+	Title     string   `json:"title"`
+	Documents []string `json:"documents,omitempty"`
+}
+
+// What happened to one document, in one format.
+type ManifestEntry struct {
+	Doc    string `json:"doc"`
+	Format string `json:"format"`
+	URL    string `json:"url"`
+	Mirror string `json:"mirror,omitempty"` // If it came from a --mirror.
+	Status string `json:"status"`
+	Bytes  int64  `json:"bytes"`
+	Error  string `json:"error,omitempty"`
+	// If the --post-hook failed for it, why.
+	HookError string `json:"hook-error,omitempty"`
+	// The other agenda sections it is listed in, if any.
+	AlsoIn []string `json:"also-listed-in,omitempty"`
+
+	// Validators from the server, used to make the next download conditional.
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last-modified,omitempty"`
+	// Checksum of our copy, also in SumsName.
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// Converts result into a ManifestEntry.
+func NewManifestEntry(result Result) ManifestEntry {
+	entry := ManifestEntry{
+		Doc:    result.Doc,
+		Format: result.Format,
+		URL:    result.URL,
+		Mirror: result.Mirror,
+		Status: kStatusDownloaded,
+		Bytes:  result.Bytes,
+
+		ETag:         result.ETag,
+		LastModified: result.LastModified,
+		SHA256:       result.SHA256,
+
+		AlsoIn: result.AlsoIn,
+	}
+	if result.Skipped {
+		entry.Status = kStatusExisted
+	}
+	if result.LinkedFrom != "" {
+		entry.Status = kStatusLinked
+	}
+	if result.CopiedFrom != "" {
+		entry.Status = kStatusCopied
+	}
+	if result.TooLarge {
+		entry.Status = kStatusTooLarge
+	}
+	if result.Missing {
+		entry.Status = kStatusMissing
+	}
+	if result.Err != nil {
+		entry.Status, entry.Error = kStatusFailed, result.Err.Error()
+	}
+	if result.HookErr != nil {
+		entry.HookError = result.HookErr.Error()
+	}
+	return entry
+}
+
+// Reads the manifest from dir, as written by writeManifest.
+// Our files there all start with prefix (see Fetcher.Prefix).
+func ReadManifest(dir string, prefix string) (Manifest, error) {
+	var m Manifest
+	data, err := ioutil.ReadFile(filepath.Join(dir, prefix+kManifestName))
+	if err != nil {
+		return m, err
+	}
+	err = json.Unmarshal(data, &m)
+	return m, err
+}
+
+// Writes the manifest for the telechat on date into dir, replacing any
+// manifest from an earlier run. Results for other dates are ignored.
+func writeManifest(dir string, prefix string, date string, docs []Doc, results []Result) error {
+	// Empty lists rather than nulls, for telechats with nothing on them.
+	documents := []string{}
+	sections := []ManifestSection{}
+	notes := make(map[string]string)
+	for i, doc := range docs {
+		// The docs are in agenda order, so each section is together.
+		if i == 0 || doc.Section != docs[i-1].Section {
+			sections = append(sections, ManifestSection{Number: doc.Section, Title: doc.SectionTitle})
+		}
+		// Management items are just the (otherwise empty) section.
+		if doc.Kind == KindManagement {
+			continue
+		}
+		documents = append(documents, doc.Name())
+		if doc.Notes() != "" {
+			notes[doc.Name()] = doc.Notes()
+		}
+		last := &sections[len(sections)-1]
+		last.Documents = append(last.Documents, doc.Name())
+	}
+	// fetchDate puts the results in the same order as docs.
+	entries := []ManifestEntry{}
+	for _, result := range results {
+		if result.Date == date {
+			entries = append(entries, NewManifestEntry(result))
+		}
+	}
+	m := Manifest{Date: date, Documents: documents, Sections: sections, Notes: notes, Downloads: entries}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, prefix+kManifestName), append(data, '\n'), 0644)
+}
@@ -0,0 +1,98 @@
+package telechat
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// Writes the PDFs results say we have for telechats into one PDF at path,
+// replacing anything there: the telechats in date order, and each one's
+// documents in agenda order. Documents we don't have a PDF of are left out
+// (and logged, unless they aren't fetched as PDFs at all).
+func (f *Fetcher) MergePDF(ctx context.Context, path string, telechats map[string][]Doc, results []Result) error {
+	path, err := Expand(path)
+	if err != nil {
+		return err
+	}
+	have := make(map[string]Result)
+	for _, result := range results {
+		if result.Format == "pdf" && result.Err == nil && !result.TooLarge {
+			have[result.Date+"/"+result.Doc] = result
+		}
+	}
+	var dates []string
+	for date := range telechats {
+		dates = append(dates, date)
+	}
+	sort.Strings(dates)
+
+	var pdfs []io.ReadSeeker
+	var missing []string
+	for _, date := range dates {
+		for _, doc := range telechats[date] {
+			// Charters (and RFCs, without IncludeRFCs) never have PDFs.
+			if !doc.downloadable() || !matchAny(f.docFormats(doc), "pdf") {
+				continue
+			}
+			result, ok := have[date+"/"+doc.Name()]
+			if !ok {
+				missing = append(missing, doc.Name())
+				continue
+			}
+			data, err := f.readStored(ctx, filepath.Join(f.Dir(date), result.File))
+			if err != nil {
+				return fmt.Errorf("error reading %v: %v", result.File, err)
+			}
+			pdfs = append(pdfs, bytes.NewReader(data))
+		}
+	}
+	if len(missing) > 0 {
+		log.Warnf("Leaving %v out of %v, we don't have PDFs of them", strings.Join(missing, ", "), path)
+	}
+	if len(pdfs) == 0 {
+		return fmt.Errorf("no PDFs to merge into %v", path)
+	}
+
+	// Don't write pdfcpu's settings into the user's config directory.
+	api.DisableConfigDir()
+	var merged bytes.Buffer
+	if err := api.MergeRaw(pdfs, &merged, false, nil); err != nil {
+		return fmt.Errorf("error merging PDFs: %v", err)
+	}
+	// So nobody reads half a file.
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, merged.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Returns the (uncompressed) contents of our copy fullname.
+func (f *Fetcher) readStored(ctx context.Context, fullname string) ([]byte, error) {
+	input, err := f.Storage.Open(ctx, fullname)
+	if err != nil {
+		return nil, err
+	}
+	defer input.Close()
+	var reader io.Reader = input
+	if f.Compress {
+		zipped, err := gzip.NewReader(input)
+		if err != nil {
+			return nil, err
+		}
+		defer zipped.Close()
+		reader = zipped
+	}
+	return ioutil.ReadAll(reader)
+}
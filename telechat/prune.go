@@ -0,0 +1,57 @@
+package telechat
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Parses an age for --prune-older-than: a Go duration ("36h"), or a whole
+// number of days ("90d"), which Go durations don't have.
+func ParseAge(s string) (time.Duration, error) {
+	if days := strings.TrimSuffix(s, "d"); days != s {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("bad number of days %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	age, err := time.ParseDuration(s)
+	if err != nil || age < 0 {
+		return 0, fmt.Errorf("bad age %q", s)
+	}
+	return age, nil
+}
+
+// Removes the telechat directories (or files) in basedir, as found by
+// Fetcher.dated, whose date is more than age before now. With dryRun we only
+// print what we would remove.
+func prune(basedir string, dirs []datedPath, age time.Duration, now time.Time, dryRun bool) error {
+	cutoff := now.Add(-age)
+	for _, dir := range dirs {
+		if !dir.date.Before(cutoff) {
+			continue
+		}
+		if dryRun {
+			fmt.Printf("Would remove %v\n", dir.path)
+			continue
+		}
+		log.Infof("Removing %v", dir.path)
+		if err := os.RemoveAll(dir.path); err != nil {
+			return err
+		}
+		// With nested layouts, tidy up the (year, say) directories once
+		// they are empty. Remove fails on ones which aren't.
+		for parent := filepath.Dir(dir.path); parent != filepath.Clean(basedir); parent = filepath.Dir(parent) {
+			if os.Remove(parent) != nil {
+				break
+			}
+		}
+	}
+	return nil
+}
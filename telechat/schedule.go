@@ -0,0 +1,77 @@
+package telechat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+)
+
+// Where the datatracker API lists telechat dates, given the first and last
+// dates we want.
+const TelechatDatesURL = "https://datatracker.ietf.org/api/v1/iesg/telechatdate/?format=json&date__gte=%s&date__lte=%s"
+
+// A page of the datatracker's list of telechat dates.
+type telechatDatePage struct {
+	Meta struct {
+		Next string `json:"next"` // Relative to the page, "" on the last one.
+	} `json:"meta"`
+	Objects []struct {
+		Date string `json:"date"`
+	} `json:"objects"`
+}
+
+// Returns the dates of the telechats from from to to (inclusive), in order,
+// according to the datatracker.
+func TelechatDates(ctx context.Context, client *http.Client, from, to string) ([]string, error) {
+	var dates []string
+	next := fmt.Sprintf(TelechatDatesURL, from, to)
+	for next != "" {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, next, nil)
+		if err != nil {
+			return nil, fmt.Errorf("error creating telechat dates request: %v", err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("error listing telechat dates: server returned %v", resp.Status)
+		}
+
+		var page telechatDatePage
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, fmt.Errorf("error unmarshalling telechat dates: %v", err)
+		}
+		for _, object := range page.Objects {
+			dates = append(dates, object.Date)
+		}
+
+		next = ""
+		if page.Meta.Next != "" {
+			u, err := req.URL.Parse(page.Meta.Next)
+			if err != nil {
+				return nil, fmt.Errorf("bad next page %q: %v", page.Meta.Next, err)
+			}
+			next = u.String()
+		}
+	}
+	sort.Strings(dates)
+	return dates, nil
+}
+
+// Returns the agenda URL for each of dates.
+func DatedAgendas(dates []string) []string {
+	var sources []string
+	for _, date := range dates {
+		sources = append(sources, fmt.Sprintf(DatedJSONURL, date))
+	}
+	return sources
+}
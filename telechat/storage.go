@@ -0,0 +1,212 @@
+package telechat
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// Where the documents end up: on the local disk (LocalStorage), or in an S3
+// bucket (s3Storage). Each is written as a partial copy first, which can be
+// added to if the download is interrupted, and only becomes the document once
+// finalized. The manifests, indexes and so on always stay on the local disk.
+//
+// Documents are named by the local path Fetcher.target gives them.
+type Storage interface {
+	// Reports whether we have name, and if so its size and modification
+	// time.
+	Exists(ctx context.Context, name string) (os.FileInfo, bool, error)
+	// Opens name for reading.
+	Open(ctx context.Context, name string) (io.ReadCloser, error)
+
+	// Returns what there is so far of the partial copy of name, and its
+	// size, or nil (and 0) if there isn't one.
+	Partial(ctx context.Context, name string) (io.ReadCloser, int64, error)
+	// Starts a partial copy of name, replacing any there already, or with
+	// resume adds to the end of the one there.
+	Create(ctx context.Context, name string, resume bool) (io.WriteCloser, error)
+	// Throws away the partial copy of name, if any.
+	Discard(ctx context.Context, name string)
+	// Makes the (complete) partial copy of name the document, replacing
+	// any earlier one. If modified isn't zero, it's when the document was
+	// last changed, according to the server.
+	Finalize(ctx context.Context, name string, modified time.Time) error
+
+	// Says where name is, for people.
+	Location(name string) string
+}
+
+// Keeps documents where Fetcher.target says, with the partial copies next to
+// them (with kPartSuffix).
+type LocalStorage struct {
+	FileMode os.FileMode
+}
+
+func (LocalStorage) Exists(ctx context.Context, name string) (os.FileInfo, bool, error) {
+	info, err := os.Stat(name)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	return info, err == nil, err
+}
+
+func (LocalStorage) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	return os.Open(name)
+}
+
+func (LocalStorage) Partial(ctx context.Context, name string) (io.ReadCloser, int64, error) {
+	file, err := os.Open(name + kPartSuffix)
+	if os.IsNotExist(err) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	return file, info.Size(), nil
+}
+
+func (s LocalStorage) Create(ctx context.Context, name string, resume bool) (io.WriteCloser, error) {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if resume {
+		flags = os.O_WRONLY | os.O_APPEND
+	}
+	return os.OpenFile(name+kPartSuffix, flags, s.FileMode)
+}
+
+func (LocalStorage) Discard(ctx context.Context, name string) {
+	os.Remove(name + kPartSuffix)
+}
+
+func (LocalStorage) Finalize(ctx context.Context, name string, modified time.Time) error {
+	if err := os.Rename(name+kPartSuffix, name); err != nil {
+		os.Remove(name + kPartSuffix)
+		return err
+	}
+	// So headChanged can tell if the server has a newer one.
+	if !modified.IsZero() {
+		os.Chtimes(name, time.Now(), modified)
+	}
+	return nil
+}
+
+func (LocalStorage) Location(name string) string {
+	return name
+}
+
+// Keeps documents in an S3 (or compatible) bucket, under a prefix. Their
+// keys are the prefix and their local path under basedir. The partial copies
+// are kept locally (by the embedded LocalStorage), and uploaded when they are
+// finalized.
+type s3Storage struct {
+	LocalStorage
+	client  *minio.Client
+	bucket  string
+	prefix  string
+	basedir string
+}
+
+// Returns the storage for the S3 (or compatible) bucket and prefix in dest
+// (s3://bucket/prefix), for the documents under basedir, with the partial
+// copies in local. endpoint is the server (a host, or a URL for plain
+// http), and the credentials come from the usual AWS_ACCESS_KEY_ID etc.
+// environment variables or ~/.aws/credentials.
+func NewS3Storage(dest string, endpoint string, region string, basedir string, local LocalStorage) (Storage, error) {
+	parsed, err := url.Parse(dest)
+	if err != nil || parsed.Scheme != "s3" || parsed.Host == "" {
+		return nil, fmt.Errorf("bad S3 destination %q, should be s3://bucket/prefix", dest)
+	}
+
+	secure := true
+	if strings.HasPrefix(endpoint, "http://") || strings.HasPrefix(endpoint, "https://") {
+		secure = strings.HasPrefix(endpoint, "https://")
+		endpoint = strings.TrimPrefix(strings.TrimPrefix(endpoint, "http://"), "https://")
+	}
+	client, err := minio.New(strings.TrimSuffix(endpoint, "/"), &minio.Options{
+		Creds: credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.FileAWSCredentials{},
+			&credentials.IAM{},
+		}),
+		Secure: secure,
+		Region: region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error making the S3 client: %v", err)
+	}
+	return &s3Storage{local, client, parsed.Host, strings.Trim(parsed.Path, "/"), basedir}, nil
+}
+
+// Returns the key for name.
+func (s *s3Storage) key(name string) string {
+	rel, err := filepath.Rel(s.basedir, name)
+	if err != nil {
+		rel = filepath.Base(name)
+	}
+	return path.Join(s.prefix, filepath.ToSlash(rel))
+}
+
+func (s *s3Storage) Exists(ctx context.Context, name string) (os.FileInfo, bool, error) {
+	info, err := s.client.StatObject(ctx, s.bucket, s.key(name), minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).StatusCode == 404 {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	return s3FileInfo{path.Base(info.Key), info.Size, info.LastModified}, true, nil
+}
+
+func (s *s3Storage) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	return s.client.GetObject(ctx, s.bucket, s.key(name), minio.GetObjectOptions{})
+}
+
+// S3 sets the modification time itself (to now), which is after the
+// server's, so headChanged still works.
+func (s *s3Storage) Finalize(ctx context.Context, name string, modified time.Time) error {
+	defer s.Discard(ctx, name)
+	_, err := s.client.FPutObject(ctx, s.bucket, s.key(name), name+kPartSuffix, minio.PutObjectOptions{
+		ContentType: mime.TypeByExtension(filepath.Ext(name)),
+	})
+	if err != nil {
+		return fmt.Errorf("error uploading %v: %v", s.Location(name), err)
+	}
+	return nil
+}
+
+func (s *s3Storage) Location(name string) string {
+	return "s3://" + s.bucket + "/" + s.key(name)
+}
+
+// What s3Storage.Exists knows about an object.
+type s3FileInfo struct {
+	name     string
+	size     int64
+	modified time.Time
+}
+
+func (i s3FileInfo) Name() string { return i.name }
+
+func (i s3FileInfo) Size() int64 { return i.size }
+
+func (i s3FileInfo) Mode() os.FileMode { return 0444 }
+
+func (i s3FileInfo) ModTime() time.Time { return i.modified }
+
+func (i s3FileInfo) IsDir() bool { return false }
+
+func (i s3FileInfo) Sys() interface{} { return nil }
@@ -0,0 +1,177 @@
+// Package telechat reads the IESG telechat agendas, and downloads the
+// documents on them into directories by date. FetchAgendas gets the
+// documents, and a Fetcher downloads them; sync_telechat is the command line
+// for it.
+package telechat
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+const (
+	// Where the JSON version of the IESG agenda lives.
+	JSONURL = "https://datatracker.ietf.org/iesg/agenda/agenda.json"
+
+	// Where the agendas of past (or future) telechats live, given the date.
+	DatedJSONURL = "https://datatracker.ietf.org/iesg/agenda/%s/agenda.json"
+
+	// How the agenda writes telechat dates, as a Go time layout.
+	DateLayout = "2006-01-02"
+
+	// Where the PDF versions of drafts live.
+	DocURL = "https://tools.ietf.org/pdf/"
+
+	// Where the text, HTML and XML versions of drafts live.
+	ArchiveURL = "https://www.ietf.org/archive/id/"
+
+	// Where charters live. These are only available as text.
+	CharterURL = "https://www.ietf.org/charter/"
+
+	// Where RFCs live, in all formats.
+	RFCURL = "https://www.rfc-editor.org/rfc/"
+
+	// Points at the most recent telechat directory.
+	LatestName = "latest"
+)
+
+// A format we know how to download documents in.
+type docFormat struct {
+	urlPrefix string // Where documents in this format live.
+	extension string // Appended to the document name, on disk and in the URL.
+}
+
+// Formats we can download, keyed by the name used with --format.
+var formats = map[string]docFormat{
+	"pdf":  {DocURL, ".pdf"},
+	"txt":  {ArchiveURL, ".txt"},
+	"html": {ArchiveURL, ".html"},
+	"xml":  {ArchiveURL, ".xml"},
+}
+
+// Formats we can download charters in.
+var charterFormats = map[string]docFormat{
+	"txt": {CharterURL, ".txt"},
+}
+
+// Formats we can download RFCs in.
+var rfcFormats = map[string]docFormat{
+	"pdf":  {RFCURL, ".pdf"},
+	"txt":  {RFCURL, ".txt"},
+	"html": {RFCURL, ".html"},
+	"xml":  {RFCURL, ".xml"},
+}
+
+// The Content-Types we expect for each format (whichever map it came from).
+var mediaTypes = map[string][]string{
+	"pdf":  {"application/pdf"},
+	"txt":  {"text/plain"},
+	"html": {"text/html", "application/xhtml+xml"},
+	"xml":  {"application/xml", "text/xml", "application/rfc+xml"},
+}
+
+// What Expand takes the OS to be. Tests change it to check the Windows rules
+// anywhere.
+var goos = runtime.GOOS
+
+// Expand home directory: "~/foo" and "~user/foo".
+// No sure why Go doesn't include a helper for this (well, I am, I just don't
+// agree :-) )
+// Windows has no ~user convention, so there we only expand a bare "~".
+func Expand(path string) (string, error) {
+	if len(path) == 0 || !strings.HasPrefix(path, "~") {
+		return path, nil
+	}
+
+	// Split "~user/rest" into the user and the rest.
+	name, rest := path[1:], ""
+	if i := strings.IndexAny(name, "/"+string(filepath.Separator)); i >= 0 {
+		name, rest = name[:i], name[i:]
+	}
+
+	var u *user.User
+	var err error
+	switch {
+	case name == "":
+		u, err = user.Current()
+	case goos == "windows":
+		return path, nil
+	default:
+		u, err = user.Lookup(name)
+		if _, ok := err.(user.UnknownUserError); ok {
+			return "", fmt.Errorf("can't expand %v: no such user %q", path, name)
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("can't expand %v: %v", path, err)
+	}
+	return filepath.Join(u.HomeDir, rest), nil
+}
+
+// Reports whether name is a format we know how to download.
+func KnownFormat(name string) bool {
+	_, ok := formats[name]
+	return ok
+}
+
+// Returns the names of the known formats, sorted, for messages.
+func FormatNames() []string {
+	var names []string
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// What we save the raw agenda as, in each date directory.
+const AgendaName = "agenda.json"
+
+// Suffix for files which are still being downloaded.
+const kPartSuffix = ".part"
+
+// Added to the names of the documents we gzip, see Fetcher.Compress.
+const GzipSuffix = ".gz"
+
+// How much of each download checkContent looks at.
+const kSniffLength = 512
+
+// Returns an error if head (the start of a download) obviously isn't a
+// document in format, which is usually because the server sent an error page
+// with a 200.
+func checkContent(format string, head []byte) error {
+	trimmed := bytes.TrimLeft(bytes.TrimPrefix(head, []byte("\xef\xbb\xbf")), " \t\r\n")
+	lower := bytes.ToLower(trimmed)
+	looksHTML := bytes.HasPrefix(lower, []byte("<!doctype html")) || bytes.HasPrefix(lower, []byte("<html"))
+	switch format {
+	case "pdf":
+		if !bytes.HasPrefix(head, []byte("%PDF-")) {
+			return errors.New("not a PDF (no %PDF- header)")
+		}
+	case "xml":
+		if !bytes.HasPrefix(trimmed, []byte("<")) || looksHTML {
+			return errors.New("not an XML document")
+		}
+	case "txt":
+		if looksHTML {
+			return errors.New("got HTML, not text")
+		}
+	case "html":
+		if !bytes.HasPrefix(trimmed, []byte("<")) {
+			return errors.New("not an HTML document")
+		}
+	}
+	return nil
+}
+
+// Returned by download when the server says our copy is current.
+var errNotModified = errors.New("not modified")
+
+// Returned by download when the document is bigger than f.MaxSize.
+var errTooLarge = errors.New("too large")
@@ -0,0 +1,50 @@
+package telechat
+
+import (
+	"os/user"
+	"path/filepath"
+	"testing"
+)
+
+func TestExpand(t *testing.T) {
+	me, err := user.Current()
+	if err != nil {
+		t.Fatalf("can't find the current user: %v", err)
+	}
+	defer func(real string) { goos = real }(goos)
+
+	tests := []struct {
+		goos string
+		path string
+		want string
+		err  string
+	}{
+		{goos: "linux", path: "", want: ""},
+		{goos: "linux", path: "foo", want: "foo"},
+		{goos: "linux", path: "~", want: me.HomeDir},
+		{goos: "linux", path: "~/x", want: filepath.Join(me.HomeDir, "x")},
+		{goos: "linux", path: "~" + me.Username + "/x", want: filepath.Join(me.HomeDir, "x")},
+		{goos: "linux", path: "~nosuchuser/x", err: `can't expand ~nosuchuser/x: no such user "nosuchuser"`},
+		// Only a bare ~ is expanded on Windows, ~user is left alone.
+		{goos: "windows", path: "foo", want: "foo"},
+		{goos: "windows", path: "~", want: me.HomeDir},
+		{goos: "windows", path: "~/x", want: filepath.Join(me.HomeDir, "x")},
+		{goos: "windows", path: "~" + me.Username + "/x", want: "~" + me.Username + "/x"},
+		{goos: "windows", path: "~nosuchuser/x", want: "~nosuchuser/x"},
+	}
+	for _, test := range tests {
+		goos = test.goos
+		got, err := Expand(test.path)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("Expand(%q) on %v returned error %v, want %q", test.path, test.goos, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Expand(%q) on %v returned error %v", test.path, test.goos, err)
+		} else if got != test.want {
+			t.Errorf("Expand(%q) on %v = %q, want %q", test.path, test.goos, got, test.want)
+		}
+	}
+}